@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	gsrpc "github.com/centrifuge/go-substrate-rpc-client"
+	"github.com/centrifuge/go-substrate-rpc-client/types"
+)
+
+// chainSource is the incident source of the on-chain checks.
+const chainSource = "chain"
+
+func chainIncidentID(check string) string {
+	return fmt.Sprintf("%s/%s", chainSource, check)
+}
+
+// Membership is the stash membership of the active and the next validator set.
+type Membership struct {
+	ActiveEra types.U32
+	// Active is true if the stash is one of the session validators.
+	Active bool
+	// AuthorityIndex is the index of the stash in the session validators if Active.
+	AuthorityIndex types.U32
+	// Exposure of the stash in the active era
+	Exposure Exposure
+	// PlannedEra is the next era once its election is done. Same as the ActiveEra until then.
+	PlannedEra types.U32
+	// Elected is true if the stash is exposed in the planned era.
+	Elected bool
+}
+
+// ChainMonitor checks the on-chain state of the stash every tick.
+type ChainMonitor struct {
+	conn  *Connection
+	stash types.AccountID
+	// networkPrefix is the SS58 prefix of the stash address
+	networkPrefix byte
+	unit          string
+	decimals      int
+	frequency     time.Duration
+	incidents     *IncidentManager
+	listeners     []Listener
+	// pointsRatio of the median points below which the stash points are alerted
+	pointsRatio float64
+	// validators are the nodes whose keystores must have the session keys
+	validators []NodeConfig
+
+	mu         sync.RWMutex
+	membership *Membership
+	// electedEra is the last planned era the election of the stash was notified for.
+	electedEra types.U32
+	// slashEras are the eras with pending slashes of the stash
+	slashEras map[types.U32]bool
+	// points of the stash in the finished eras
+	points map[types.U32]EraPoints
+}
+
+func NewChainMonitor(conn *Connection, config Config, incidents *IncidentManager,
+	listeners []Listener) (*ChainMonitor, error) {
+	stash, err := getAccountID(config.Payout.Stash)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, err := getNetworkPrefix(config.Payout.Stash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChainMonitor{
+		conn:          conn,
+		stash:         stash,
+		networkPrefix: prefix,
+		unit:          config.Payout.Unit,
+		decimals:      config.Payout.Decimals,
+		frequency:     config.MonitorFrequency.Duration,
+		incidents:     incidents,
+		listeners:     listeners,
+		pointsRatio:   config.PointsAlertRatio,
+		validators:    config.validatorNodes(),
+		points:        make(map[types.U32]EraPoints),
+	}, nil
+}
+
+// Start registers the stash event handlers on the event bus and runs the checks every tick
+// until the ctx is cancelled.
+func (m *ChainMonitor) Start(ctx context.Context, bus *EventBus) {
+	log.Println("Starting chain monitor...")
+	bus.Subscribe(m.handleOffenceEvents)
+	bus.OnEraPayout(m.handleEraPayout)
+	go func() {
+		tick := time.NewTicker(m.frequency)
+		defer tick.Stop()
+		for {
+			m.check()
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+}
+
+func (m *ChainMonitor) check() {
+	if err := m.conn.API().CheckRuntimeUpgrade(); err != nil {
+		log.Printf("failed to check runtime upgrade: %v\n", err)
+	}
+
+	membership, err := fetchMembership(m.conn.API(), m.stash)
+	if err != nil {
+		log.Printf("failed to fetch validator set membership: %v\n", err)
+	} else {
+		m.checkMembership(membership)
+		if err := m.checkUnappliedSlashes(membership.ActiveEra); err != nil {
+			log.Printf("failed to check unapplied slashes: %v\n", err)
+		}
+
+		if err := m.checkHeartbeat(membership); err != nil {
+			log.Printf("failed to check heartbeat: %v\n", err)
+		}
+
+		if err := m.checkPoints(membership); err != nil {
+			log.Printf("failed to check era points: %v\n", err)
+		}
+	}
+
+	if _, err := m.CheckSessionKeys(); err != nil {
+		log.Printf("failed to check session keys: %v\n", err)
+	}
+}
+
+func (m *ChainMonitor) checkMembership(ms Membership) {
+	m.mu.Lock()
+	m.membership = &ms
+	notified := m.electedEra
+	m.mu.Unlock()
+
+	id := chainIncidentID("active_set")
+	if ms.Active {
+		m.incidents.Resolve(id)
+	} else {
+		m.incidents.Fire(Incident{
+			ID:       id,
+			Source:   chainSource,
+			Severity: Alert,
+			Message:  fmt.Sprintf("Stash is not in the active validator set of era %d", ms.ActiveEra),
+			Details:  ms,
+		})
+	}
+
+	// election of the next era is not done yet. Once the planned era starts, not being elected is
+	// reported by active_set.
+	id = chainIncidentID("next_era")
+	if ms.PlannedEra <= ms.ActiveEra {
+		m.incidents.Resolve(id)
+		return
+	}
+
+	if !ms.Elected {
+		m.incidents.Fire(Incident{
+			ID:       id,
+			Source:   chainSource,
+			Severity: Warn,
+			Message:  fmt.Sprintf("Stash is not elected for era %d", ms.PlannedEra),
+			Details:  ms,
+		})
+		return
+	}
+
+	m.incidents.Resolve(id)
+	if notified == ms.PlannedEra {
+		return
+	}
+
+	m.mu.Lock()
+	m.electedEra = ms.PlannedEra
+	m.mu.Unlock()
+	m.incidents.Notify(Incident{
+		ID:       chainIncidentID("elected"),
+		Source:   chainSource,
+		Severity: Info,
+		Message:  fmt.Sprintf("Stash is elected for era %d", ms.PlannedEra),
+		Details:  ms,
+	})
+}
+
+// CheckSessionKeys checks if the validator nodes can sign for the next session keys of the stash
+// and returns the result. Keystores are checked over the RPC of the validator nodes themselves,
+// never over the chain connection that may be failed over to another node.
+func (m *ChainMonitor) CheckSessionKeys() (string, error) {
+	keys, err := fetchNextKeys(m.conn.API(), m.stash)
+	if err != nil {
+		return "", err
+	}
+
+	id := chainIncidentID("session_keys")
+	if len(keys) == 0 {
+		msg := "Stash has no session keys set on chain"
+		m.incidents.Fire(Incident{ID: id, Source: chainSource, Severity: Alert, Message: msg})
+		return msg, nil
+	}
+
+	m.incidents.Resolve(id)
+	hexKeys := types.HexEncodeToString(keys)
+	var buf strings.Builder
+	for _, n := range m.validators {
+		id := chainIncidentID(fmt.Sprintf("session_keys/%s", n.Name))
+		has, err := hasSessionKeys(n.RPCURL, hexKeys)
+		if err != nil {
+			// unreachable node is alerted by the node checks
+			log.Printf("failed to check session keys of node %s: %v\n", n.Name, err)
+			buf.WriteString(fmt.Sprintf("Failed to check the session keys of node %s: %v\n", n.Name, err))
+			continue
+		}
+
+		if !has {
+			msg := fmt.Sprintf("Node %s keystore is missing the on-chain session keys %s", n.Name, hexKeys)
+			m.incidents.Fire(Incident{ID: id, Source: chainSource, Severity: Alert, Message: msg})
+			buf.WriteString(msg + "\n")
+			continue
+		}
+
+		m.incidents.Resolve(id)
+		buf.WriteString(fmt.Sprintf("Node %s has the on-chain session keys %s\n", n.Name, hexKeys))
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// MembershipStatus returns the last checked validator set membership of the stash.
+func (m *ChainMonitor) MembershipStatus() string {
+	m.mu.RLock()
+	ms := m.membership
+	m.mu.RUnlock()
+	if ms == nil {
+		return "Validator set membership is not checked yet"
+	}
+
+	var buf strings.Builder
+	status := "not active"
+	if ms.Active {
+		status = "active"
+	}
+	buf.WriteString(fmt.Sprintf("Era %d: %s\n", ms.ActiveEra, status))
+	total, own := big.Int(ms.Exposure.Total), big.Int(ms.Exposure.Own)
+	buf.WriteString(fmt.Sprintf("Total stake: %s\nOwn stake: %s\nNominators: %d\n",
+		formatBalance(&total, m.decimals, m.unit), formatBalance(&own, m.decimals, m.unit),
+		len(ms.Exposure.Others)))
+
+	switch {
+	case ms.PlannedEra <= ms.ActiveEra:
+		buf.WriteString(fmt.Sprintf("Era %d: election pending", ms.ActiveEra+1))
+	case ms.Elected:
+		buf.WriteString(fmt.Sprintf("Era %d: elected", ms.PlannedEra))
+	default:
+		buf.WriteString(fmt.Sprintf("Era %d: not elected", ms.PlannedEra))
+	}
+
+	return buf.String()
+}
+
+func fetchMembership(api *ChainAPI, stash types.AccountID) (Membership, error) {
+	era, err := activeEra(api)
+	if err != nil {
+		return Membership{}, err
+	}
+
+	ms := Membership{ActiveEra: era, PlannedEra: era}
+	var validators []types.AccountID
+	err = fetchStorage(api, "Session", "Validators", nil, nil, &validators)
+	if err != nil {
+		return ms, err
+	}
+
+	for i, v := range validators {
+		if v == stash {
+			ms.Active, ms.AuthorityIndex = true, types.U32(i)
+			break
+		}
+	}
+
+	ms.Exposure, err = fetchExposure(api, era, stash)
+	if err != nil {
+		return ms, err
+	}
+
+	planned, err := currentEra(api)
+	if err != nil {
+		return ms, err
+	}
+
+	if planned <= era {
+		return ms, nil
+	}
+
+	exposure, err := fetchExposure(api, planned, stash)
+	if err != nil {
+		return ms, err
+	}
+
+	total := big.Int(exposure.Total)
+	ms.PlannedEra, ms.Elected = planned, total.Sign() > 0
+	return ms, nil
+}
+
+// formatBalance returns the balance in units with 2 decimals.
+func formatBalance(v *big.Int, decimals int, unit string) string {
+	r := new(big.Rat).SetFrac(v, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	return fmt.Sprintf("%s %s", r.FloatString(2), unit)
+}
+
+// fetchNextKeys returns the encoded session keys of the stash for the next session.
+// Keys are empty if the stash has not set any.
+func fetchNextKeys(api *ChainAPI, stash types.AccountID) ([]byte, error) {
+	meta, err := api.LatestMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := types.CreateStorageKey(meta, "Session", "NextKeys", stash[:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// keys are returned raw as the session keys type differs between runtimes
+	raw, err := api.RPC.State.GetStorageRawLatest(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return *raw, nil
+}
+
+// hasSessionKeys returns true if the keystore of the node at the RPC url has the private keys
+// of the encoded session keys.
+func hasSessionKeys(url, hexKeys string) (bool, error) {
+	api, err := gsrpc.NewSubstrateAPI(url)
+	if err != nil {
+		return false, err
+	}
+
+	defer closeAPI(api)
+	var has bool
+	return has, api.Client.Call(&has, "author_hasSessionKeys", hexKeys)
+}
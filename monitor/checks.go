@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Check is a health check run against the node metrics on every tick.
+// Checks keep their own state between the ticks.
+type Check interface {
+	Name() string
+	Run(current Metrics) CheckResult
+}
+
+// CheckResult is the outcome of a check on a single tick.
+type CheckResult struct {
+	Check    string
+	Healthy  bool
+	Severity Severity
+	Message  string
+}
+
+func healthy(check string) CheckResult {
+	return CheckResult{Check: check, Healthy: true}
+}
+
+func unhealthy(check string, severity Severity, message string) CheckResult {
+	return CheckResult{Check: check, Severity: severity, Message: message}
+}
+
+// HealthReport is the aggregated result of all the checks of a node on a tick.
+type HealthReport struct {
+	Node    string
+	Time    time.Time
+	Results []CheckResult
+}
+
+// Healthy returns true if all the checks passed.
+func (r HealthReport) Healthy() bool {
+	for _, res := range r.Results {
+		if !res.Healthy {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r HealthReport) String() string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("Health report of %s at %s:", r.Node, r.Time.Format(time.RFC3339)))
+	for _, res := range r.Results {
+		status := "ok"
+		if !res.Healthy {
+			status = fmt.Sprintf("%s: %s", res.Severity, res.Message)
+		}
+		buf.WriteString(fmt.Sprintf("\n  %s: %s", res.Check, status))
+	}
+
+	return buf.String()
+}
+
+// RunChecks runs every check and reports the results.
+func RunChecks(node NodeConfig, checks []Check, current Metrics) HealthReport {
+	report := HealthReport{Node: node.Name, Time: time.Now()}
+	for _, c := range checks {
+		report.Results = append(report.Results, c.Run(current))
+	}
+
+	return report
+}
+
+// NewChecks returns the default node checks.
+func NewChecks(config Config) []Check {
+	return []Check{
+		&majorSyncCheck{},
+		&finalityCheck{},
+		&peersCheck{},
+		&blockProductionCheck{frequency: config.MonitorFrequency.Duration},
+	}
+}
+
+type majorSyncCheck struct{}
+
+func (c *majorSyncCheck) Name() string {
+	return "major_sync"
+}
+
+func (c *majorSyncCheck) Run(current Metrics) CheckResult {
+	if current.IsMajorSyncing {
+		return unhealthy(c.Name(), Warn, "Node is in Major Sync")
+	}
+
+	return healthy(c.Name())
+}
+
+// finalityCheck fails when the finalized block hasn't moved since the last tick.
+type finalityCheck struct {
+	prev *bint
+}
+
+func (c *finalityCheck) Name() string {
+	return "finality"
+}
+
+func (c *finalityCheck) Run(current Metrics) CheckResult {
+	finalized := current.BlockHeight.Finalized
+	if finalized == nil {
+		return unhealthy(c.Name(), Warn, "Node didn't report the finalised block")
+	}
+
+	if c.prev != nil && finalized.Cmp(&c.prev.Int) <= 0 {
+		return unhealthy(c.Name(), Alert,
+			fmt.Sprintf("Node hasn't finalised new block since `%s`", c.prev.String()))
+	}
+
+	c.prev = finalized
+	return healthy(c.Name())
+}
+
+type peersCheck struct{}
+
+func (c *peersCheck) Name() string {
+	return "peers"
+}
+
+func (c *peersCheck) Run(current Metrics) CheckResult {
+	if current.Peers < 1 {
+		return unhealthy(c.Name(), Alert, "Node has 0 peers")
+	}
+
+	return healthy(c.Name())
+}
+
+// blockProductionCheck fails when a validating node didn't produce a new block since the last healthy tick.
+type blockProductionCheck struct {
+	frequency time.Duration
+	prev      ValidatorStats
+}
+
+func (c *blockProductionCheck) Name() string {
+	return "block_production"
+}
+
+func (c *blockProductionCheck) Run(current Metrics) CheckResult {
+	vs := current.ValidatorStats
+	if c.prev.IsValidating && (!vs.IsValidating || vs.LastProduced.Cmp(&c.prev.LastProduced.Int) <= 0) {
+		return unhealthy(c.Name(), Warn,
+			fmt.Sprintf("Node didn't produce blocks in last %d minutes", int(c.frequency.Minutes())))
+	}
+
+	c.prev = vs
+	return healthy(c.Name())
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/octago/sflags"
+	"sigs.k8s.io/yaml"
+)
+
+// envPrefix is prepended to the flag derived names of the environment variables that override the config.
+// Example: MONITOR_TELEGRAM_KEY, MONITOR_PAYOUT_HOT_WALLET_URI
+const envPrefix = "MONITOR_"
+
+type Config struct {
+	Name             string   `json:"name"`
+	MonitorFrequency Duration `json:"monitor_frequency"`
+	// DataDir is where the monitor keeps its state between restarts.
+	DataDir string `json:"data_dir"`
+
+	TelegramKey         string `json:"telegram_key"`
+	TelegramChatID      string `json:"telegram_chat_id"`
+	TelegramSeverity    int    `json:"telegram_severity"`
+	TelegramBotUsername string `json:"telegram_bot_username"`
+
+	PagerdutyAPIKey    string `json:"pagerduty_api_key"`
+	PagerdutySeverity  int    `json:"pagerduty_severity"`
+	PagerdutyEventsURL string `json:"pagerduty_events_url"`
+
+	// RenotifyInterval after which a still firing incident is notified again. Zero disables re-notification.
+	RenotifyInterval Duration `json:"renotify_interval"`
+
+	// Nodes to monitor. Defaults to a single local node when empty.
+	// Accountant uses the RPC of the first node.
+	Nodes []NodeConfig `json:"nodes"`
+
+	// RPCEndpoints are the websocket RPC endpoints the chain monitor and the accountant fail over to
+	// after the RPC endpoints of the nodes.
+	RPCEndpoints []string `json:"rpc_endpoints"`
+
+	// FinalizedEvents processes only the chain events of the finalized blocks so the events of the
+	// blocks that are later reorged out never trigger payouts or alerts. Events are delayed until finalization.
+	FinalizedEvents bool `json:"finalized_events"`
+
+	// Rules are the metric threshold rules evaluated against every node.
+	Rules []MetricRule `json:"rules"`
+
+	// LogRules are matched against the logs of every node.
+	// Defaults are used when not set. Set to an empty list to disable.
+	LogRules []LogRule `json:"log_rules"`
+
+	// PointsAlertRatio alerts when the points of the stash in the last finished era fell below this fraction
+	// of the active set median. Zero disables the alert.
+	PointsAlertRatio float64 `json:"points_alert_ratio"`
+
+	Payout struct {
+		Stash        string `json:"stash"`
+		HotWalletURI string `json:"hot_wallet_uri"`
+		Decimals     int    `json:"decimals"`
+		Unit         string `json:"unit"`
+		// DryRun reports the planned payouts and their fees instead of submitting them.
+		DryRun bool `json:"dry_run"`
+		// MaxFee in units above which a payout batch is skipped. Zero disables the limit.
+		MaxFee float64 `json:"max_fee"`
+		// MortalPeriod is the number of blocks a payout extrinsic stays valid for. It is rebuilt and
+		// resubmitted if not included by then. Zero makes the extrinsics immortal.
+		MortalPeriod uint64 `json:"mortal_period"`
+	} `json:"payout"`
+}
+
+// NodeConfig is a single node target watched by the monitor.
+type NodeConfig struct {
+	Name          string    `json:"name"`
+	PrometheusURL string    `json:"prometheus_url"`
+	RPCURL        string    `json:"rpc_url"`
+	Log           LogConfig `json:"log"`
+	// Validator marks the node that validates for the stash. Its keystore is checked for the session keys.
+	// First node is the validator if none is marked.
+	Validator bool `json:"validator"`
+}
+
+// LogConfig is the source of the node logs used to detect block production.
+type LogConfig struct {
+	// Type of the log source. One of journald(default), file or docker.
+	Type string `json:"type"`
+	// Unit is the journald unit of the node
+	Unit string `json:"unit"`
+	// Path of the log file
+	Path string `json:"path"`
+	// Container is the name or ID of the docker container of the node
+	Container string `json:"container"`
+}
+
+func defaultNodeConfig(name string) NodeConfig {
+	return NodeConfig{
+		Name:          name,
+		PrometheusURL: "http://127.0.0.1:9615/metrics",
+		RPCURL:        "ws://127.0.0.1:9944",
+		Log:           LogConfig{Type: LogSourceJournald, Unit: "centrifuge"},
+	}
+}
+
+// withNodeDefaults fills the missing node details with the local node defaults.
+func (c Config) withNodeDefaults() Config {
+	if len(c.Nodes) < 1 {
+		c.Nodes = []NodeConfig{defaultNodeConfig(c.Name)}
+		return c
+	}
+
+	nodes := make([]NodeConfig, len(c.Nodes))
+	for i, n := range c.Nodes {
+		def := defaultNodeConfig(fmt.Sprintf("%s-%d", c.Name, i))
+		if n.Name == "" {
+			n.Name = def.Name
+		}
+		if n.PrometheusURL == "" {
+			n.PrometheusURL = def.PrometheusURL
+		}
+		if n.RPCURL == "" {
+			n.RPCURL = def.RPCURL
+		}
+		if n.Log.Type == "" {
+			n.Log.Type = LogSourceJournald
+		}
+		if n.Log.Type == LogSourceJournald && n.Log.Unit == "" {
+			n.Log.Unit = def.Log.Unit
+		}
+		nodes[i] = n
+	}
+	c.Nodes = nodes
+	return c
+}
+
+// ChainEndpoints returns the RPC endpoints of the nodes followed by the extra RPC endpoints, without duplicates.
+func (c Config) ChainEndpoints() []string {
+	seen := make(map[string]bool)
+	var endpoints []string
+	for _, e := range append(c.nodeRPCURLs(), c.RPCEndpoints...) {
+		if e == "" || seen[e] {
+			continue
+		}
+
+		seen[e] = true
+		endpoints = append(endpoints, e)
+	}
+
+	return endpoints
+}
+
+// validatorNodes returns the nodes that validate for the stash.
+func (c Config) validatorNodes() []NodeConfig {
+	var nodes []NodeConfig
+	for _, n := range c.Nodes {
+		if n.Validator {
+			nodes = append(nodes, n)
+		}
+	}
+
+	if len(nodes) < 1 && len(c.Nodes) > 0 {
+		nodes = c.Nodes[:1]
+	}
+
+	return nodes
+}
+
+func (c Config) nodeRPCURLs() []string {
+	var urls []string
+	for _, n := range c.Nodes {
+		urls = append(urls, n.RPCURL)
+	}
+
+	return urls
+}
+
+func (c Config) IsTelegramBotEnabled() bool {
+	return c.TelegramKey != "" && c.TelegramChatID != ""
+}
+
+func defaultConfig() Config {
+	dataDir := ".monitor"
+	if home, err := os.UserHomeDir(); err == nil {
+		dataDir = filepath.Join(home, ".monitor")
+	}
+
+	config := Config{
+		MonitorFrequency:  Duration{time.Minute * 5},
+		DataDir:           dataDir,
+		RenotifyInterval:  Duration{time.Hour},
+		PagerdutySeverity: int(Alert),
+		PointsAlertRatio:  0.5,
+		Name:              "Monitor",
+	}
+	config.Payout.Decimals = 1
+	config.Payout.MortalPeriod = 64
+	return config
+}
+
+// LoadConfig builds the config from defaults, the config file at path(if any), environment variables and
+// the flag overrides, in that order.
+// overrides are flag name -> value pairs explicitly set on the command line.
+func LoadConfig(path string, overrides map[string]string) (Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		err := readConfigFile(path, &config)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	flags, err := sflags.ParseStruct(&config, sflags.EnvPrefix(envPrefix))
+	if err != nil {
+		return config, err
+	}
+
+	for _, f := range flags {
+		if f.EnvName == "" {
+			continue
+		}
+
+		v, ok := os.LookupEnv(f.EnvName)
+		if !ok {
+			continue
+		}
+
+		if err := f.Value.Set(v); err != nil {
+			return config, fmt.Errorf("invalid value for %s: %w", f.EnvName, err)
+		}
+	}
+
+	for _, f := range flags {
+		v, ok := overrides[f.Name]
+		if !ok {
+			continue
+		}
+
+		if err := f.Value.Set(v); err != nil {
+			return config, fmt.Errorf("invalid value for -%s: %w", f.Name, err)
+		}
+	}
+
+	if config.LogRules == nil {
+		config.LogRules = defaultLogRules()
+	}
+
+	config = config.withNodeDefaults()
+	return config, config.validate()
+}
+
+// validate checks the parts of the config that are otherwise only found invalid once in use.
+func (c Config) validate() error {
+	if _, err := compileRules(c.Rules); err != nil {
+		return err
+	}
+
+	if _, err := compileLogRules(c.LogRules); err != nil {
+		return err
+	}
+
+	for _, n := range c.Nodes {
+		if _, err := NewLogSource(n, c.DataDir); err != nil {
+			return err
+		}
+	}
+
+	if c.Payout.Stash != "" {
+		if _, err := decodeAddress(c.Payout.Stash); err != nil {
+			return fmt.Errorf("payout stash: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func readConfigFile(path string, config *Config) error {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(d, config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(d, config)
+	default:
+		return fmt.Errorf("unknown config file format: %s", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Duration is a time.Duration that is read from and written to config files as a string. Example: "5m"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	return d.Set(s)
+}
+
+// Set implements sflags.Value so the duration can be set from flags and environment variables.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = v
+	return nil
+}
+
+func (d *Duration) Type() string {
+	return "duration"
+}
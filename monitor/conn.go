@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	gsrpc "github.com/centrifuge/go-substrate-rpc-client"
+)
+
+const (
+	// healthCheckInterval is how often the connected endpoint is checked.
+	healthCheckInterval = 30 * time.Second
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+// Connection keeps a websocket RPC connection to the first healthy endpoint.
+// It reconnects with exponential backoff when the connection fails and fails over to the next
+// endpoint when the connected one is unhealthy. Endpoints are preferred in the given order.
+type Connection struct {
+	endpoints []string
+	incidents *IncidentManager
+	failures  chan error
+	// metadata is shared by the connections to any of the endpoints as they serve the same chain
+	metadata *MetadataCache
+
+	mu       sync.RWMutex
+	api      *ChainAPI
+	endpoint string
+	// ready is closed while connected and replaced on disconnect
+	ready chan struct{}
+}
+
+func NewConnection(endpoints []string, incidents *IncidentManager) *Connection {
+	return &Connection{
+		endpoints: endpoints,
+		incidents: incidents,
+		failures:  make(chan error, 1),
+		metadata:  NewMetadataCache(),
+		ready:     make(chan struct{}),
+	}
+}
+
+// Metadata returns the metadata cache of the chain.
+func (c *Connection) Metadata() *MetadataCache {
+	return c.metadata
+}
+
+// API returns the current connection. It is nil until the first connection.
+func (c *Connection) API() *ChainAPI {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.api
+}
+
+// Ready returns a channel that is closed once connected.
+func (c *Connection) Ready() <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ready
+}
+
+// Failed reports that the api failed with the err. Connection is reestablished unless it already was.
+func (c *Connection) Failed(api *ChainAPI, err error) {
+	if api != c.API() {
+		return
+	}
+
+	select {
+	case c.failures <- err:
+	default:
+	}
+}
+
+// Start keeps the connection until the ctx is cancelled.
+func (c *Connection) Start(ctx context.Context) {
+	backoff := minReconnectBackoff
+	for {
+		if !c.connect() {
+			c.incidents.Fire(Incident{
+				ID:       chainIncidentID("connection"),
+				Source:   chainSource,
+				Severity: Warn,
+				Message:  fmt.Sprintf("Failed to connect to any of %v. Retrying in %v", c.endpoints, backoff),
+			})
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			continue
+		}
+
+		backoff = minReconnectBackoff
+		err := c.watch(ctx)
+		if ctx.Err() != nil {
+			c.disconnect()
+			return
+		}
+
+		endpoint := c.disconnect()
+		log.Printf("Connection to %s lost: %v\n", endpoint, err)
+		c.incidents.Fire(Incident{
+			ID:       chainIncidentID("connection"),
+			Source:   chainSource,
+			Severity: Warn,
+			Message:  fmt.Sprintf("Connection to %s lost: %v", endpoint, err),
+		})
+	}
+}
+
+// connect connects to the first healthy endpoint.
+func (c *Connection) connect() bool {
+	c.mu.RLock()
+	previous := c.endpoint
+	c.mu.RUnlock()
+
+	for _, endpoint := range c.endpoints {
+		api, err := gsrpc.NewSubstrateAPI(endpoint)
+		if err != nil {
+			log.Printf("Failed to connect to %s: %v\n", endpoint, err)
+			continue
+		}
+
+		if err := checkHealth(api); err != nil {
+			log.Printf("Endpoint %s is unhealthy: %v\n", endpoint, err)
+			closeAPI(api)
+			continue
+		}
+
+		c.mu.Lock()
+		c.api, c.endpoint = &ChainAPI{SubstrateAPI: api, metadata: c.metadata}, endpoint
+		close(c.ready)
+		c.mu.Unlock()
+
+		log.Printf("Connected to %s\n", endpoint)
+		c.incidents.Resolve(chainIncidentID("connection"))
+		if previous != "" && previous != endpoint {
+			c.incidents.Notify(Incident{
+				ID:       chainIncidentID("failover"),
+				Source:   chainSource,
+				Severity: Warn,
+				Message:  fmt.Sprintf("Failed over from %s to %s", previous, endpoint),
+			})
+		}
+		return true
+	}
+
+	return false
+}
+
+// watch returns once the connection fails or the endpoint is unhealthy.
+func (c *Connection) watch(ctx context.Context) error {
+	tick := time.NewTicker(healthCheckInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-c.failures:
+			return err
+		case <-tick.C:
+			if err := checkHealth(c.API().SubstrateAPI); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// disconnect closes the current connection and returns its endpoint.
+func (c *Connection) disconnect() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.api != nil {
+		closeAPI(c.api.SubstrateAPI)
+	}
+	c.ready = make(chan struct{})
+	// drop the failures of the closed connection
+	select {
+	case <-c.failures:
+	default:
+	}
+	return c.endpoint
+}
+
+// checkHealth returns an error if the node is unreachable, syncing or has no peers.
+func checkHealth(api *gsrpc.SubstrateAPI) error {
+	health, err := api.RPC.System.Health()
+	if err != nil {
+		return err
+	}
+
+	if health.IsSyncing {
+		return errors.New("node is syncing")
+	}
+
+	if health.ShouldHavePeers && health.Peers == 0 {
+		return errors.New("node has no peers")
+	}
+
+	return nil
+}
+
+func closeAPI(api *gsrpc.SubstrateAPI) {
+	if api == nil {
+		return
+	}
+
+	if c, ok := api.Client.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/rpc/state"
+	"github.com/centrifuge/go-substrate-rpc-client/types"
+)
+
+// EventRecords are the gsrpc event records with the events it doesn't decode or decodes differently
+// than the runtime.
+type EventRecords struct {
+	types.EventRecords
+	Offences_Offence     []EventOffencesOffence
+	ImOnline_SomeOffline []EventImOnlineSomeOffline
+	Staking_Chilled      []EventStakingChilled
+}
+
+// EventHandler handles the decoded events of a block.
+type EventHandler func(block types.Hash, events EventRecords)
+
+// EventBus decodes the System.Events of every block once and fans them out to the handlers.
+// Handlers are called in the order of the blocks and must not block for long.
+type EventBus struct {
+	conn      *Connection
+	incidents *IncidentManager
+	// finalized processes only the events of the finalized blocks
+	finalized bool
+	// statePath is the file the last processed block number is saved to
+	statePath string
+	// last is the last processed block number. saved is the last one written to the statePath.
+	last  uint64
+	saved uint64
+
+	mu       sync.RWMutex
+	handlers []EventHandler
+}
+
+func NewEventBus(conn *Connection, incidents *IncidentManager, finalized bool, statePath string) *EventBus {
+	return &EventBus{conn: conn, incidents: incidents, finalized: finalized, statePath: statePath}
+}
+
+// Subscribe registers the handler for the events of every block.
+func (b *EventBus) Subscribe(handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// OnEraPayout calls handle with the era of every Staking.EraPayout event.
+func (b *EventBus) OnEraPayout(handle func(block types.Hash, era types.U32)) {
+	b.Subscribe(func(block types.Hash, events EventRecords) {
+		for _, e := range events.Staking_EraPayout {
+			handle(block, e.EraIndex)
+		}
+	})
+}
+
+// OnReward calls handle for every Staking.Reward event of the stash.
+func (b *EventBus) OnReward(stash types.AccountID, handle func(block types.Hash, stash types.AccountID,
+	amount types.U128)) {
+	b.Subscribe(func(block types.Hash, events EventRecords) {
+		for _, e := range events.Staking_Reward {
+			if !bytes.Equal(e.Stash[:], stash[:]) {
+				continue
+			}
+
+			handle(block, e.Stash, e.Amount)
+		}
+	})
+}
+
+// Start pumps the events to the handlers until the ctx is cancelled.
+// Events of the blocks missed since the last processed block are replayed first.
+// Failed subscription is reported to the connection and renewed once reconnected.
+func (b *EventBus) Start(ctx context.Context) {
+	b.loadLast()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.conn.Ready():
+		}
+
+		api := b.conn.API()
+		var err error
+		if b.finalized {
+			err = b.listenForFinalizedEvents(ctx, api)
+		} else {
+			err = b.listenForBestEvents(ctx, api)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("Chain events subscription stopped: %v. Resubscribing...\n", err)
+		b.conn.Failed(api, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// publishRaw decodes the events of the block with the metadata and publishes them.
+// Undecodable events are alerted and skipped as decoding fails the same way on every retry.
+func (b *EventBus) publishRaw(block types.Hash, meta *types.Metadata, raw types.EventRecordsRaw) {
+	var events EventRecords
+	err := raw.DecodeEventRecords(meta, &events)
+	if err != nil {
+		log.Printf("failed to decode events of block %s: %v\n", block.Hex(), err)
+		b.incidents.Fire(Incident{
+			ID:       chainIncidentID("events"),
+			Source:   chainSource,
+			Severity: Alert,
+			Message: fmt.Sprintf("Failed to decode events of block %s: %v. Its events are skipped",
+				block.Hex(), err),
+		})
+		return
+	}
+
+	b.incidents.Resolve(chainIncidentID("events"))
+	b.publish(block, events)
+}
+
+func (b *EventBus) publish(block types.Hash, events EventRecords) {
+	if len(events.System_CodeUpdated) > 0 {
+		log.Printf("Runtime code updated in block %s. Refreshing metadata...\n", block.Hex())
+		b.conn.Metadata().Invalidate()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, h := range b.handlers {
+		h(block, events)
+	}
+}
+
+func getEventSubscription(api *ChainAPI) (sub *state.StorageSubscription, key types.StorageKey,
+	err error) {
+	meta, err := api.LatestMetadata()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Subscribe to system events via storage
+	key, err = types.CreateStorageKey(meta, "System", "Events", nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sub, err = api.RPC.State.SubscribeStorageRaw([]types.StorageKey{key})
+	return sub, key, err
+}
+
+// listenForEvents calls onEvents with the encoded events of every block until the ctx is cancelled,
+// the subscription fails or onEvents returns an error.
+func listenForEvents(ctx context.Context, api *ChainAPI,
+	onEvents func(block types.Hash, raw types.EventRecordsRaw) error) error {
+	log.Println("Watching for chain events...")
+	sub, key, err := getEventSubscription(api)
+	if err != nil {
+		return err
+	}
+
+	defer sub.Unsubscribe()
+
+	start := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-sub.Err():
+			return fmt.Errorf("subscription failed after %v: %w", time.Since(start), err)
+		case set := <-sub.Chan():
+			// inner loop for the changes within one of those notifications
+			for _, chng := range set.Changes {
+				if !types.Eq(chng.StorageKey, key) || !chng.HasStorageData {
+					// skip, we are only interested in events with content
+					continue
+				}
+
+				err := onEvents(set.Block, types.EventRecordsRaw(chng.StorageData))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// listenForBestEvents publishes the events of the best blocks. Blocks missed since the last processed one
+// are replayed before the first notified block. Blocks already processed are skipped, so the best block
+// notified again after resubscribing is not published twice.
+func (b *EventBus) listenForBestEvents(ctx context.Context, api *ChainAPI) error {
+	return listenForEvents(ctx, api, func(block types.Hash, raw types.EventRecordsRaw) error {
+		header, err := api.RPC.Chain.GetHeader(block)
+		if err != nil {
+			return err
+		}
+
+		number := uint64(header.Number)
+		if number <= b.last {
+			return nil
+		}
+
+		if b.last > 0 && number > b.last+1 {
+			err = b.processBlocks(ctx, api, number-1)
+			if err != nil {
+				return err
+			}
+		}
+
+		meta, err := api.MetadataAt(header.ParentHash)
+		if err != nil {
+			return fmt.Errorf("failed to fetch metadata of block %d: %w", number, err)
+		}
+
+		b.publishRaw(block, meta, raw)
+		b.last = number
+		b.saveLast()
+		return nil
+	})
+}
+
+// listenForFinalizedEvents publishes the events of every finalized block in order until the ctx is cancelled
+// or the subscription fails. Blocks finalized together and the ones finalized while the monitor was down
+// are fetched so none is skipped.
+func (b *EventBus) listenForFinalizedEvents(ctx context.Context, api *ChainAPI) error {
+	log.Println("Watching for finalized chain events...")
+	sub, err := api.RPC.Chain.SubscribeFinalizedHeads()
+	if err != nil {
+		return err
+	}
+
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-sub.Err():
+			return err
+		case head := <-sub.Chan():
+			err := b.processBlocks(ctx, api, uint64(head.Number))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+const (
+	// maxReplayBlocks is the number of the blocks replayed at most after the monitor was down.
+	maxReplayBlocks = 28800
+	// saveInterval is the number of the blocks replayed between saving the last processed block.
+	saveInterval = 100
+)
+
+// prunedStateErrors are the errors of the nodes that no longer keep the state of old blocks.
+var prunedStateErrors = []string{"State already discarded", "UnknownBlock"}
+
+func isPrunedState(err error) bool {
+	for _, e := range prunedStateErrors {
+		if strings.Contains(err.Error(), e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// processBlocks publishes the events of the blocks after the last processed one up to the block number.
+// Events are decoded with the metadata of the runtime that emitted them. Only the block itself is processed
+// if none was processed before.
+func (b *EventBus) processBlocks(ctx context.Context, api *ChainAPI, to uint64) error {
+	defer b.saveLast()
+	switch {
+	case b.last == 0:
+		b.last = to - 1
+	case b.last+1 < to:
+		if to-b.last > maxReplayBlocks {
+			log.Printf("Skipping the events of blocks %d to %d\n", b.last+1, to-maxReplayBlocks)
+			b.last = to - maxReplayBlocks
+		}
+		log.Printf("Replaying the events of blocks %d to %d...\n", b.last+1, to)
+	}
+
+	for b.last < to {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		number := b.last + 1
+		hash, err := api.RPC.Chain.GetBlockHash(number)
+		if err != nil {
+			return err
+		}
+
+		meta, raw, err := fetchBlockEvents(api, hash)
+		switch {
+		case err == nil:
+			b.publishRaw(hash, meta, raw)
+		case isPrunedState(err):
+			log.Printf("State of block %d is pruned. Skipping its events\n", number)
+		default:
+			return fmt.Errorf("failed to fetch events of block %d: %w", number, err)
+		}
+
+		b.last = number
+		if number%saveInterval == 0 {
+			b.saveLast()
+		}
+	}
+
+	return nil
+}
+
+// fetchBlockEvents returns the encoded events of the block and the metadata to decode them with.
+func fetchBlockEvents(api *ChainAPI, hash types.Hash) (*types.Metadata, types.EventRecordsRaw, error) {
+	meta, err := api.EventsMetadata(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, err := fetchEventsRaw(api, meta, hash)
+	return meta, raw, err
+}
+
+func (b *EventBus) loadLast() {
+	d, err := ioutil.ReadFile(b.statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("failed to read the last processed block: %v\n", err)
+		}
+		return
+	}
+
+	last, err := strconv.ParseUint(strings.TrimSpace(string(d)), 10, 64)
+	if err != nil {
+		log.Printf("invalid last processed block: %v\n", err)
+		return
+	}
+
+	b.last, b.saved = last, last
+}
+
+// saveLast saves the last processed block unless it is already saved.
+func (b *EventBus) saveLast() {
+	if b.last == b.saved {
+		return
+	}
+
+	err := writeFileAtomic(b.statePath, []byte(strconv.FormatUint(b.last, 10)))
+	if err != nil {
+		log.Printf("failed to save the last processed block: %v\n", err)
+		return
+	}
+
+	b.saved = b.last
+}
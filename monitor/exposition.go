@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricType is the type of a metric family as declared by the TYPE metadata.
+type MetricType string
+
+const (
+	MetricCounter        MetricType = "counter"
+	MetricGauge          MetricType = "gauge"
+	MetricHistogram      MetricType = "histogram"
+	MetricGaugeHistogram MetricType = "gaugehistogram"
+	MetricSummary        MetricType = "summary"
+	MetricInfo           MetricType = "info"
+	MetricStateSet       MetricType = "stateset"
+	MetricUntyped        MetricType = "untyped"
+	MetricUnknown        MetricType = "unknown"
+)
+
+// suffixes are the sample name suffixes each metric type can expose in addition to the family name.
+var suffixes = map[MetricType][]string{
+	MetricCounter:        {"_total", "_created"},
+	MetricHistogram:      {"_bucket", "_sum", "_count", "_created"},
+	MetricGaugeHistogram: {"_bucket", "_gsum", "_gcount"},
+	MetricSummary:        {"_sum", "_count", "_created"},
+	MetricInfo:           {"_info"},
+}
+
+// Labels of a single sample.
+type Labels map[string]string
+
+func (l Labels) String() string {
+	if len(l) < 1 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	buf.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(fmt.Sprintf("%s=%q", k, l[k]))
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// Sample is a single series value from the exposition.
+type Sample struct {
+	Name   string
+	Labels Labels
+	Value  float64
+	// Timestamp in milliseconds. Zero when the exposition didn't carry one.
+	Timestamp int64
+}
+
+// MetricFamily groups the samples of a metric with its metadata.
+type MetricFamily struct {
+	Name    string
+	Help    string
+	Unit    string
+	Type    MetricType
+	Samples []Sample
+}
+
+// Families are the metric families keyed by the family name.
+type Families map[string]*MetricFamily
+
+// Select returns the samples with the sample name matching all the matchers.
+func (f Families) Select(name string, matchers ...*LabelMatcher) []Sample {
+	var res []Sample
+	for _, family := range f {
+		if !strings.HasPrefix(name, family.Name) {
+			continue
+		}
+
+		for _, s := range family.Samples {
+			if s.Name != name || !matchAll(s.Labels, matchers) {
+				continue
+			}
+
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
+
+// Value returns the value of the first sample selected by name and matchers.
+func (f Families) Value(name string, matchers ...*LabelMatcher) (float64, bool) {
+	s := f.Select(name, matchers...)
+	if len(s) < 1 {
+		return 0, false
+	}
+
+	return s[0].Value, true
+}
+
+// MatchType is the label matching operator.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// LabelMatcher matches a label value of a sample.
+type LabelMatcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+func NewLabelMatcher(t MatchType, name, value string) (*LabelMatcher, error) {
+	m := &LabelMatcher{Name: name, Type: t, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("unknown match type: %s", t)
+	}
+
+	return m, nil
+}
+
+// MustLabelMatcher is like NewLabelMatcher but panics on invalid matchers.
+func MustLabelMatcher(t MatchType, name, value string) *LabelMatcher {
+	m, err := NewLabelMatcher(t, name, value)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// Matches returns true if the label value satisfies the matcher.
+// Missing labels are matched as empty values.
+func (m *LabelMatcher) Matches(l Labels) bool {
+	v := l[m.Name]
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+
+	return false
+}
+
+func (m *LabelMatcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+func matchAll(l Labels, matchers []*LabelMatcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(l) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseSelector parses a series selector of the form `name{label="value",other=~"regex"}`.
+func ParseSelector(selector string) (name string, matchers []*LabelMatcher, err error) {
+	selector = strings.TrimSpace(selector)
+	i := strings.IndexByte(selector, '{')
+	if i < 0 {
+		if !isValidMetricName(selector) {
+			return "", nil, fmt.Errorf("invalid metric name: %q", selector)
+		}
+		return selector, nil, nil
+	}
+
+	name = strings.TrimSpace(selector[:i])
+	if !isValidMetricName(name) {
+		return "", nil, fmt.Errorf("invalid metric name: %q", name)
+	}
+
+	p := &lineParser{s: selector, pos: i + 1}
+	for {
+		p.skipSpaces()
+		if p.consume('}') {
+			break
+		}
+
+		lname := p.readLabelName()
+		if lname == "" {
+			return "", nil, p.errorf("expected label name")
+		}
+
+		p.skipSpaces()
+		var op MatchType
+		for _, t := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+			if strings.HasPrefix(p.s[p.pos:], string(t)) {
+				op = t
+				p.pos += len(t)
+				break
+			}
+		}
+		if op == "" {
+			return "", nil, p.errorf("expected match operator")
+		}
+
+		p.skipSpaces()
+		value, err := p.readQuoted()
+		if err != nil {
+			return "", nil, err
+		}
+
+		m, err := NewLabelMatcher(op, lname, value)
+		if err != nil {
+			return "", nil, err
+		}
+		matchers = append(matchers, m)
+
+		p.skipSpaces()
+		if p.consume(',') {
+			continue
+		}
+		if !p.consume('}') {
+			return "", nil, p.errorf("expected , or }")
+		}
+		break
+	}
+
+	if strings.TrimSpace(p.s[p.pos:]) != "" {
+		return "", nil, p.errorf("unexpected trailing characters")
+	}
+
+	return name, matchers, nil
+}
+
+// openMetricsContentType is the media type of the OpenMetrics text exposition.
+const openMetricsContentType = "application/openmetrics-text"
+
+// isOpenMetrics returns true if the exposition is OpenMetrics by its content type or the # EOF terminator
+// only OpenMetrics has.
+func isOpenMetrics(data []byte, contentType string) bool {
+	if strings.HasPrefix(strings.TrimSpace(contentType), openMetricsContentType) {
+		return true
+	}
+
+	data = bytes.TrimSpace(data)
+	return bytes.Equal(data, []byte("# EOF")) || bytes.HasSuffix(data, []byte("\n# EOF"))
+}
+
+// ParseExposition parses the Prometheus text format and OpenMetrics exposition into metric families.
+// Content type is the one the exposition was served with and may be empty.
+func ParseExposition(data []byte, contentType string) (Families, error) {
+	openMetrics := isOpenMetrics(data, contentType)
+	families := make(Families)
+	var current *MetricFamily
+	family := func(name string) *MetricFamily {
+		f, ok := families[name]
+		if !ok {
+			f = &MetricFamily{Name: name, Type: MetricUntyped}
+			families[name] = f
+		}
+		current = f
+		return f
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if line == "# EOF" {
+			break
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(strings.TrimSpace(line[1:]), " ", 3)
+			if len(fields) < 3 || (fields[0] != "HELP" && fields[0] != "UNIT" && fields[0] != "TYPE") {
+				// plain comment
+				continue
+			}
+
+			f := family(fields[1])
+			switch fields[0] {
+			case "HELP":
+				f.Help = fields[2]
+			case "UNIT":
+				f.Unit = fields[2]
+			case "TYPE":
+				f.Type = MetricType(strings.ToLower(fields[2]))
+			}
+			continue
+		}
+
+		s, err := parseSample(line, openMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		f := current
+		if f == nil || !belongsTo(f, s.Name) {
+			f = family(s.Name)
+		}
+		f.Samples = append(f.Samples, s)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return families, nil
+}
+
+func belongsTo(f *MetricFamily, name string) bool {
+	if name == f.Name {
+		return true
+	}
+
+	for _, suffix := range suffixes[f.Type] {
+		if name == f.Name+suffix {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseSample parses the sample line. OpenMetrics timestamps are in seconds
+// and the Prometheus text format ones in milliseconds.
+func parseSample(line string, openMetrics bool) (Sample, error) {
+	p := &lineParser{s: line}
+	s := Sample{Labels: make(Labels)}
+	s.Name = p.readMetricName()
+	if s.Name == "" {
+		return s, p.errorf("expected metric name")
+	}
+
+	if p.consume('{') {
+		for {
+			p.skipSpaces()
+			if p.consume('}') {
+				break
+			}
+
+			name := p.readLabelName()
+			if name == "" {
+				return s, p.errorf("expected label name")
+			}
+
+			p.skipSpaces()
+			if !p.consume('=') {
+				return s, p.errorf("expected =")
+			}
+
+			p.skipSpaces()
+			value, err := p.readQuoted()
+			if err != nil {
+				return s, err
+			}
+
+			if _, ok := s.Labels[name]; ok {
+				return s, p.errorf("duplicate label %s", name)
+			}
+			s.Labels[name] = value
+
+			p.skipSpaces()
+			if p.consume(',') {
+				continue
+			}
+			if !p.consume('}') {
+				return s, p.errorf("expected , or }")
+			}
+			break
+		}
+	}
+
+	if !p.skipSpaces() {
+		return s, p.errorf("expected space before value")
+	}
+
+	v, err := parseFloat(p.readToken())
+	if err != nil {
+		return s, err
+	}
+	s.Value = v
+
+	p.skipSpaces()
+	if p.done() || p.peek() == '#' {
+		// OpenMetrics exemplars are ignored
+		return s, nil
+	}
+
+	ts := p.readToken()
+	if openMetrics {
+		sec, err := strconv.ParseFloat(ts, 64)
+		if err != nil {
+			return s, p.errorf("invalid timestamp %q", ts)
+		}
+		s.Timestamp = int64(math.Round(sec * 1000))
+	} else {
+		ms, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return s, p.errorf("invalid timestamp %q", ts)
+		}
+		s.Timestamp = ms
+	}
+
+	p.skipSpaces()
+	if !p.done() && p.peek() != '#' {
+		return s, p.errorf("unexpected trailing characters")
+	}
+
+	return s, nil
+}
+
+func parseFloat(s string) (float64, error) {
+	switch s {
+	case "+Inf", "Inf", "+inf", "inf":
+		return math.Inf(1), nil
+	case "-Inf", "-inf":
+		return math.Inf(-1), nil
+	case "NaN", "nan":
+		return math.NaN(), nil
+	case "":
+		return 0, errors.New("missing value")
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+
+	return v, nil
+}
+
+func isValidMetricName(name string) bool {
+	p := &lineParser{s: name}
+	return name != "" && p.readMetricName() == name
+}
+
+// lineParser is a cursor over a single exposition line.
+type lineParser struct {
+	s   string
+	pos int
+}
+
+func (p *lineParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d in %q", fmt.Sprintf(format, args...), p.pos, p.s)
+}
+
+func (p *lineParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *lineParser) peek() byte {
+	return p.s[p.pos]
+}
+
+func (p *lineParser) consume(c byte) bool {
+	if p.done() || p.peek() != c {
+		return false
+	}
+
+	p.pos++
+	return true
+}
+
+// skipSpaces returns true if any space was skipped.
+func (p *lineParser) skipSpaces() bool {
+	start := p.pos
+	for !p.done() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *lineParser) readWhile(valid func(c byte, first bool) bool) string {
+	start := p.pos
+	for !p.done() && valid(p.peek(), p.pos == start) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *lineParser) readMetricName() string {
+	return p.readWhile(func(c byte, first bool) bool {
+		return isLetter(c) || c == '_' || c == ':' || (!first && isDigit(c))
+	})
+}
+
+func (p *lineParser) readLabelName() string {
+	return p.readWhile(func(c byte, first bool) bool {
+		return isLetter(c) || c == '_' || (!first && isDigit(c))
+	})
+}
+
+func (p *lineParser) readToken() string {
+	return p.readWhile(func(c byte, _ bool) bool {
+		return c != ' ' && c != '\t'
+	})
+}
+
+func (p *lineParser) readQuoted() (string, error) {
+	if !p.consume('"') {
+		return "", p.errorf("expected \"")
+	}
+
+	var buf strings.Builder
+	for !p.done() {
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return buf.String(), nil
+		case '\\':
+			if p.done() {
+				return "", p.errorf("unterminated escape")
+			}
+			e := p.peek()
+			p.pos++
+			switch e {
+			case 'n':
+				buf.WriteByte('\n')
+			case '\\', '"':
+				buf.WriteByte(e)
+			default:
+				buf.WriteByte('\\')
+				buf.WriteByte(e)
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+
+	return "", p.errorf("unterminated label value")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
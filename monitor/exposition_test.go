@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExposition(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		contentType string
+		selector    string
+		want        []Sample
+	}{
+		{
+			name:     "plain sample",
+			data:     "substrate_sub_libp2p_peers_count 12\n",
+			selector: "substrate_sub_libp2p_peers_count",
+			want:     []Sample{{Name: "substrate_sub_libp2p_peers_count", Labels: Labels{}, Value: 12}},
+		},
+		{
+			name: "reordered labels",
+			data: `substrate_block_height{chain="polkadot",status="finalized"} 100
+substrate_block_height{status="best",chain="polkadot"} 102
+`,
+			selector: `substrate_block_height{status="best"}`,
+			want: []Sample{{
+				Name:   "substrate_block_height",
+				Labels: Labels{"status": "best", "chain": "polkadot"},
+				Value:  102,
+			}},
+		},
+		{
+			name:     "extra labels",
+			data:     `substrate_block_height{status="finalized",chain="kusama",instance="a"} 7` + "\n",
+			selector: `substrate_block_height{status="finalized"}`,
+			want: []Sample{{
+				Name:   "substrate_block_height",
+				Labels: Labels{"status": "finalized", "chain": "kusama", "instance": "a"},
+				Value:  7,
+			}},
+		},
+		{
+			name:     "escaped label value",
+			data:     `foo{path="C:\\dir\"x\"\nend"} 1` + "\n",
+			selector: "foo",
+			want:     []Sample{{Name: "foo", Labels: Labels{"path": "C:\\dir\"x\"\nend"}, Value: 1}},
+		},
+		{
+			name:     "prometheus timestamp in milliseconds",
+			data:     "foo 1 1520879607789\n",
+			selector: "foo",
+			want:     []Sample{{Name: "foo", Labels: Labels{}, Value: 1, Timestamp: 1520879607789}},
+		},
+		{
+			name:     "openmetrics timestamp in seconds",
+			data:     "foo 1 1520879607\n# EOF\n",
+			selector: "foo",
+			want:     []Sample{{Name: "foo", Labels: Labels{}, Value: 1, Timestamp: 1520879607000}},
+		},
+		{
+			name:        "openmetrics content type",
+			data:        "foo 1 1520879607.789\n",
+			contentType: "application/openmetrics-text; version=1.0.0; charset=utf-8",
+			selector:    "foo",
+			want:        []Sample{{Name: "foo", Labels: Labels{}, Value: 1, Timestamp: 1520879607789}},
+		},
+		{
+			name: "histogram buckets",
+			data: `# HELP request_seconds Request duration.
+# TYPE request_seconds histogram
+request_seconds_bucket{le="0.1"} 3
+request_seconds_bucket{le="+Inf"} 5
+request_seconds_sum 1.5
+request_seconds_count 5
+`,
+			selector: `request_seconds_bucket{le="+Inf"}`,
+			want:     []Sample{{Name: "request_seconds_bucket", Labels: Labels{"le": "+Inf"}, Value: 5}},
+		},
+		{
+			name:     "exemplar",
+			data:     `foo_total 3 # {trace_id="abc"} 1` + "\n# EOF\n",
+			selector: "foo_total",
+			want:     []Sample{{Name: "foo_total", Labels: Labels{}, Value: 3}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families, err := ParseExposition([]byte(tt.data), tt.contentType)
+			if err != nil {
+				t.Fatalf("ParseExposition() error = %v", err)
+			}
+
+			name, matchers, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("ParseSelector() error = %v", err)
+			}
+
+			if got := families.Select(name, matchers...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Select() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExpositionHistogram(t *testing.T) {
+	data := `# TYPE request_seconds histogram
+# UNIT request_seconds seconds
+request_seconds_bucket{le="0.1"} 3
+request_seconds_bucket{le="+Inf"} 5
+request_seconds_sum 1.5
+request_seconds_count 5
+# EOF
+`
+	families, err := ParseExposition([]byte(data), "")
+	if err != nil {
+		t.Fatalf("ParseExposition() error = %v", err)
+	}
+
+	f, ok := families["request_seconds"]
+	if !ok {
+		t.Fatalf("family request_seconds missing in %v", families)
+	}
+
+	if f.Type != MetricHistogram || f.Unit != "seconds" || len(f.Samples) != 4 {
+		t.Errorf("family = %+v, want a histogram in seconds with 4 samples", f)
+	}
+
+	if v, _ := families.Value("request_seconds_bucket", MustLabelMatcher(MatchEqual, "le", "+Inf")); v != 5 {
+		t.Errorf("+Inf bucket = %v, want 5", v)
+	}
+
+	if len(families) != 1 {
+		t.Errorf("families = %v, want only request_seconds", families)
+	}
+}
+
+func TestParseExpositionSpecialValues(t *testing.T) {
+	families, err := ParseExposition([]byte("a +Inf\nb -Inf\nc NaN\n"), "")
+	if err != nil {
+		t.Fatalf("ParseExposition() error = %v", err)
+	}
+
+	if v, _ := families.Value("a"); !math.IsInf(v, 1) {
+		t.Errorf("a = %v, want +Inf", v)
+	}
+
+	if v, _ := families.Value("b"); !math.IsInf(v, -1) {
+		t.Errorf("b = %v, want -Inf", v)
+	}
+
+	if v, _ := families.Value("c"); !math.IsNaN(v) {
+		t.Errorf("c = %v, want NaN", v)
+	}
+}
+
+func TestParseExpositionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{name: "missing value", data: "foo\n", err: "expected space before value"},
+		{name: "invalid value", data: "foo bar\n", err: "invalid value"},
+		{name: "unterminated labels", data: `foo{a="b" 1` + "\n", err: "expected , or }"},
+		{name: "unquoted label value", data: "foo{a=b} 1\n", err: `expected "`},
+		{name: "duplicate label", data: `foo{a="b",a="c"} 1` + "\n", err: "duplicate label a"},
+		{name: "invalid timestamp", data: "foo 1 soon\n", err: "invalid timestamp"},
+		{name: "fractional prometheus timestamp", data: "foo 1 1520879607.789\n", err: "invalid timestamp"},
+		{name: "trailing characters", data: "foo 1 2 3\n", err: "unexpected trailing characters"},
+		{name: "line number", data: "foo 1\nbar\n", err: "line 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseExposition([]byte(tt.data), "")
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseExposition() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/centrifuge/go-substrate-rpc-client/types"
+)
+
+// maxListedNominators is the number of the added and removed nominators listed in the era summary.
+const maxListedNominators = 10
+
+// ExposureDiff is the change of the stash exposure from the previous era.
+type ExposureDiff struct {
+	Era               types.U32
+	Previous, Current Exposure
+	Added, Removed    []types.AccountID
+	// Oversubscribed is the number of the nominators beyond the rewarded ones.
+	Oversubscribed int
+}
+
+func diffExposure(era types.U32, prev, cur Exposure, maxRewarded int) ExposureDiff {
+	diff := ExposureDiff{Era: era, Previous: prev, Current: cur}
+	prevNominators := make(map[types.AccountID]bool)
+	for _, o := range prev.Others {
+		prevNominators[o.Who] = true
+	}
+
+	curNominators := make(map[types.AccountID]bool)
+	for _, o := range cur.Others {
+		curNominators[o.Who] = true
+		if !prevNominators[o.Who] {
+			diff.Added = append(diff.Added, o.Who)
+		}
+	}
+
+	for _, o := range prev.Others {
+		if !curNominators[o.Who] {
+			diff.Removed = append(diff.Removed, o.Who)
+		}
+	}
+
+	if maxRewarded > 0 && len(cur.Others) > maxRewarded {
+		diff.Oversubscribed = len(cur.Others) - maxRewarded
+	}
+
+	return diff
+}
+
+// handleEraPayout sends the exposure summary of the era that starts after the paid out era.
+func (m *ChainMonitor) handleEraPayout(block types.Hash, era types.U32) {
+	prev, err := fetchExposure(m.conn.API(), era, m.stash)
+	if err != nil {
+		log.Printf("failed to fetch exposure of era %d: %v\n", era, err)
+		return
+	}
+
+	cur, err := fetchExposure(m.conn.API(), era+1, m.stash)
+	if err != nil {
+		log.Printf("failed to fetch exposure of era %d: %v\n", era+1, err)
+		return
+	}
+
+	var maxRewarded types.U32
+	err = fetchConstant(m.conn.API(), "Staking", "MaxNominatorRewardedPerValidator", &maxRewarded)
+	if err != nil {
+		log.Printf("failed to fetch max rewarded nominators: %v\n", err)
+	}
+
+	sendMessage(m.eraSummary(diffExposure(era+1, prev, cur, int(maxRewarded))), m.listeners)
+}
+
+func (m *ChainMonitor) eraSummary(diff ExposureDiff) string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("Era %d summary\n", diff.Era))
+	buf.WriteString(fmt.Sprintf("Total stake: %s\n", m.formatChange(diff.Previous.Total, diff.Current.Total)))
+	buf.WriteString(fmt.Sprintf("Own stake: %s\n", m.formatChange(diff.Previous.Own, diff.Current.Own)))
+	buf.WriteString(fmt.Sprintf("Nominators: %d (+%d/-%d)\n", len(diff.Current.Others), len(diff.Added),
+		len(diff.Removed)))
+	m.writeNominators(&buf, "New", diff.Added)
+	m.writeNominators(&buf, "Removed", diff.Removed)
+	if diff.Oversubscribed > 0 {
+		buf.WriteString(fmt.Sprintf("Oversubscribed: %d nominators are not rewarded\n", diff.Oversubscribed))
+	}
+
+	return strings.TrimSpace(buf.String())
+}
+
+func (m *ChainMonitor) writeNominators(buf *strings.Builder, title string, nominators []types.AccountID) {
+	if len(nominators) == 0 {
+		return
+	}
+
+	buf.WriteString(fmt.Sprintf("%s:\n", title))
+	for i, n := range nominators {
+		if i == maxListedNominators {
+			buf.WriteString(fmt.Sprintf("and %d more\n", len(nominators)-i))
+			break
+		}
+
+		buf.WriteString(fmt.Sprintf("%s\n", getAddress(n, m.networkPrefix)))
+	}
+}
+
+// formatChange returns the current balance with the change from the previous one.
+func (m *ChainMonitor) formatChange(prev, cur types.UCompact) string {
+	p, c := big.Int(prev), big.Int(cur)
+	change := new(big.Int).Sub(&c, &p)
+	sign := ""
+	if change.Sign() >= 0 {
+		sign = "+"
+	}
+
+	return fmt.Sprintf("%s (%s%s)", formatBalance(&c, m.decimals, m.unit), sign,
+		formatBalance(change, m.decimals, m.unit))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/centrifuge/go-substrate-rpc-client/types"
+)
+
+// PayoutResult is the outcome of a finalized payout batch.
+type PayoutResult struct {
+	Block types.Hash
+	Paid  []types.U32
+	// Failed is the era the batch was interrupted at with the Error. Eras after it are Skipped.
+	Failed  *types.U32
+	Skipped []types.U32
+	Error   string
+}
+
+func (r PayoutResult) String() string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("Payout finalized in block %s\n", r.Block.Hex()))
+	if len(r.Paid) > 0 {
+		buf.WriteString(fmt.Sprintf("Paid eras: %v\n", r.Paid))
+	}
+
+	if r.Failed != nil {
+		buf.WriteString(fmt.Sprintf("Failed era: %d: %s\n", *r.Failed, r.Error))
+	} else if r.Error != "" {
+		buf.WriteString(fmt.Sprintf("Failed: %s\n", r.Error))
+	}
+
+	if len(r.Skipped) > 0 {
+		buf.WriteString(fmt.Sprintf("Not paid eras: %v\n", r.Skipped))
+	}
+
+	return strings.TrimSpace(buf.String())
+}
+
+// fetchPayoutResult decodes which eras of the batch extrinsic were paid from the events of the block.
+func fetchPayoutResult(api *ChainAPI, block types.Hash, ext types.Extrinsic,
+	eras []types.U32) (PayoutResult, error) {
+	res := PayoutResult{Block: block}
+	index, err := extrinsicIndex(api, block, ext)
+	if err != nil {
+		return res, err
+	}
+
+	meta, err := api.EventsMetadata(block)
+	if err != nil {
+		return res, err
+	}
+
+	events, err := fetchEvents(api, meta, block)
+	if err != nil {
+		return res, err
+	}
+
+	ofExtrinsic := func(phase types.Phase) bool {
+		return phase.IsApplyExtrinsic && phase.AsApplyExtrinsic == index
+	}
+
+	for _, e := range events.System_ExtrinsicFailed {
+		if ofExtrinsic(e.Phase) {
+			res.Skipped, res.Error = eras, dispatchErrorString(meta, e.DispatchError)
+			return res, nil
+		}
+	}
+
+	for _, e := range events.Utility_BatchInterrupted {
+		if !ofExtrinsic(e.Phase) || int(e.Index) >= len(eras) {
+			continue
+		}
+
+		failed := eras[e.Index]
+		res.Paid, res.Failed, res.Skipped = eras[:e.Index], &failed, eras[e.Index+1:]
+		res.Error = dispatchErrorString(meta, e.DispatchError)
+		return res, nil
+	}
+
+	for _, e := range events.Utility_BatchCompleted {
+		if ofExtrinsic(e.Phase) {
+			res.Paid = eras
+			return res, nil
+		}
+	}
+
+	return res, errors.New("no batch outcome event found for the extrinsic")
+}
+
+// extrinsicIndex returns the index of the extrinsic in the block.
+func extrinsicIndex(api *ChainAPI, block types.Hash, ext types.Extrinsic) (uint32, error) {
+	want, err := types.EncodeToHexString(ext)
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := api.RPC.Chain.GetBlock(block)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, x := range b.Block.Extrinsics {
+		enc, err := types.EncodeToHexString(x)
+		if err != nil {
+			return 0, err
+		}
+
+		if enc == want {
+			return uint32(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("extrinsic not found in block %s", block.Hex())
+}
+
+// fetchEvents returns the decoded events of the block.
+func fetchEvents(api *ChainAPI, meta *types.Metadata, block types.Hash) (EventRecords, error) {
+	var events EventRecords
+	raw, err := fetchEventsRaw(api, meta, block)
+	if err != nil {
+		return events, err
+	}
+
+	return events, raw.DecodeEventRecords(meta, &events)
+}
+
+// fetchEventsRaw returns the encoded events of the block.
+func fetchEventsRaw(api *ChainAPI, meta *types.Metadata, block types.Hash) (types.EventRecordsRaw, error) {
+	key, err := types.CreateStorageKey(meta, "System", "Events", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := api.RPC.State.GetStorageRaw(key, block)
+	if err != nil {
+		return nil, err
+	}
+
+	return types.EventRecordsRaw(*raw), nil
+}
+
+// dispatchErrorString returns the module error name with its module and error index.
+func dispatchErrorString(meta *types.Metadata, de types.DispatchError) string {
+	if !de.HasModule {
+		return "dispatch error"
+	}
+
+	name := "unknown error"
+	if meta.IsMetadataV12 {
+		for _, mod := range meta.AsMetadataV12.Modules {
+			if mod.Index != de.Module || int(de.Error) >= len(mod.Errors) {
+				continue
+			}
+
+			name = fmt.Sprintf("%s.%s", mod.Name, mod.Errors[de.Error].Name)
+			break
+		}
+	}
+
+	return fmt.Sprintf("%s (module %d, error %d)", name, de.Module, de.Error)
+}
@@ -8,5 +8,7 @@ require (
 	github.com/octago/sflags v0.2.0
 	github.com/stretchr/testify v1.6.1 // indirect
 	github.com/vedhavyas/tgo v0.0.0-20201005142218-aafa3bde5461
+	golang.org/x/crypto v0.0.0-20200115085410-6d4e4cb37c7d
+	sigs.k8s.io/yaml v1.2.0
 
 )
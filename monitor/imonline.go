@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/centrifuge/go-substrate-rpc-client/types"
+)
+
+// heartbeatWarnProgress is the session progress after which a missing heartbeat is warned about.
+const heartbeatWarnProgress = 0.8
+
+// Liveness is the im-online status of the stash in the current session.
+type Liveness struct {
+	Session types.U32
+	// Progress is the fraction of the session done.
+	Progress       float64
+	Heartbeat      bool
+	AuthoredBlocks types.U32
+}
+
+// checkHeartbeat warns when the session is about to end without a heartbeat or an authored block of the stash.
+// The stash is reported offline at the end of such session.
+func (m *ChainMonitor) checkHeartbeat(ms Membership) error {
+	id := chainIncidentID("heartbeat")
+	if !ms.Active {
+		m.incidents.Resolve(id)
+		return nil
+	}
+
+	liveness, err := fetchLiveness(m.conn.API(), m.stash, ms.AuthorityIndex)
+	if err != nil {
+		return err
+	}
+
+	if liveness.Heartbeat || liveness.AuthoredBlocks > 0 || liveness.Progress < heartbeatWarnProgress {
+		m.incidents.Resolve(id)
+		return nil
+	}
+
+	m.incidents.Fire(Incident{
+		ID:       id,
+		Source:   chainSource,
+		Severity: Warn,
+		Message: fmt.Sprintf("No heartbeat or authored block in session %d and %.0f%% of it is done",
+			liveness.Session, liveness.Progress*100),
+		Details: liveness,
+	})
+	return nil
+}
+
+func fetchLiveness(api *ChainAPI, stash types.AccountID, authIndex types.U32) (Liveness, error) {
+	var res Liveness
+	err := fetchStorage(api, "Session", "CurrentIndex", nil, nil, &res.Session)
+	if err != nil {
+		return res, err
+	}
+
+	res.Progress, err = sessionProgress(api)
+	if err != nil {
+		return res, err
+	}
+
+	session, err := types.EncodeToBytes(res.Session)
+	if err != nil {
+		return res, err
+	}
+
+	index, err := types.EncodeToBytes(authIndex)
+	if err != nil {
+		return res, err
+	}
+
+	var heartbeat types.Bytes
+	res.Heartbeat, err = fetchStorageOk(api, "ImOnline", "ReceivedHeartbeats", session, index, &heartbeat)
+	if err != nil {
+		return res, err
+	}
+
+	_, err = fetchStorageOk(api, "ImOnline", "AuthoredBlocks", session, stash[:], &res.AuthoredBlocks)
+	return res, err
+}
+
+// sessionProgress returns the fraction of the current session done. Sessions are the babe epochs.
+func sessionProgress(api *ChainAPI) (float64, error) {
+	var duration types.U64
+	err := fetchConstant(api, "Babe", "EpochDuration", &duration)
+	if err != nil {
+		return 0, err
+	}
+
+	if duration == 0 {
+		return 0, errors.New("babe epoch duration is zero")
+	}
+
+	var epoch, genesisSlot, currentSlot types.U64
+	for _, s := range []struct {
+		method string
+		target *types.U64
+	}{{"EpochIndex", &epoch}, {"GenesisSlot", &genesisSlot}, {"CurrentSlot", &currentSlot}} {
+		err = fetchStorage(api, "Babe", s.method, nil, nil, s.target)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	start := genesisSlot + epoch*duration
+	if currentSlot < start {
+		return 0, nil
+	}
+
+	return float64(currentSlot-start) / float64(duration), nil
+}
+
+// fetchConstant decodes the module constant from the latest metadata into target.
+func fetchConstant(api *ChainAPI, module, name string, target interface{}) error {
+	meta, err := api.LatestMetadata()
+	if err != nil {
+		return err
+	}
+
+	if !meta.IsMetadataV12 {
+		return fmt.Errorf("unsupported metadata version %d", meta.Version)
+	}
+
+	for _, mod := range meta.AsMetadataV12.Modules {
+		if string(mod.Name) != module {
+			continue
+		}
+
+		for _, c := range mod.Constants {
+			if string(c.Name) == name {
+				return types.DecodeFromBytes(c.Value, target)
+			}
+		}
+	}
+
+	return fmt.Errorf("constant %s.%s not found", module, name)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type IncidentState int
+
+const (
+	// IncidentPending incidents are active but not for long enough to notify.
+	IncidentPending IncidentState = iota
+	// IncidentFiring incidents are active and notified.
+	IncidentFiring
+	// IncidentResolved incidents are no longer active.
+	IncidentResolved
+)
+
+func (s IncidentState) String() string {
+	switch s {
+	case IncidentPending:
+		return "pending"
+	case IncidentFiring:
+		return "firing"
+	case IncidentResolved:
+		return "resolved"
+	}
+
+	return fmt.Sprintf("IncidentState(%d)", int(s))
+}
+
+// Incident is an alert condition identified by ID that is tracked across ticks.
+type Incident struct {
+	// ID is the incident identity. Same condition must always fire with the same ID.
+	ID string
+	// Source is the node or component the incident is about.
+	Source   string
+	Severity Severity
+	Message  string
+	// For is how long the incident should be active before it is notified.
+	For time.Duration
+	// Details are the additional context of the incident. Example: node metrics
+	Details interface{}
+
+	State        IncidentState
+	ActiveSince  time.Time
+	LastNotified time.Time
+}
+
+// String returns the message tagged with the source.
+func (i Incident) String() string {
+	msg := i.Message
+	if i.State == IncidentResolved {
+		msg = fmt.Sprintf("Resolved: %s", msg)
+	}
+
+	if i.Source == "" {
+		return msg
+	}
+
+	return fmt.Sprintf("[%s] %s", i.Source, msg)
+}
+
+// IncidentListener is implemented by the listeners that handle the incident lifecycle themselves.
+// Other listeners are notified of firing incidents with the incident severity and of resolved ones with Info.
+type IncidentListener interface {
+	NotifyIncident(incident Incident)
+}
+
+// IncidentManager tracks the incidents by identity and notifies the listeners only on state transitions
+// and every renotify interval while the incident keeps firing.
+type IncidentManager struct {
+	mu        sync.Mutex
+	listeners []Listener
+	renotify  time.Duration
+	incidents map[string]*Incident
+}
+
+// NewIncidentManager returns a new incident manager. Zero renotify disables re-notification.
+func NewIncidentManager(renotify time.Duration, listeners []Listener) *IncidentManager {
+	return &IncidentManager{
+		listeners: listeners,
+		renotify:  renotify,
+		incidents: make(map[string]*Incident),
+	}
+}
+
+// Configure replaces the listeners and the renotify interval on config reload. Tracked incidents are
+// kept so the ones still firing are not notified again and are resolved on the new listeners.
+func (m *IncidentManager) Configure(renotify time.Duration, listeners []Listener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renotify, m.listeners = renotify, listeners
+}
+
+// Fire marks the incident as active.
+func (m *IncidentManager) Fire(incident Incident) {
+	m.mu.Lock()
+	now := time.Now()
+	current, ok := m.incidents[incident.ID]
+	if !ok {
+		incident.State = IncidentPending
+		incident.ActiveSince = now
+		current = &incident
+		m.incidents[incident.ID] = current
+	} else {
+		// keep the latest details
+		current.Severity, current.Message, current.For = incident.Severity, incident.Message, incident.For
+		current.Details = incident.Details
+	}
+
+	notify := false
+	switch {
+	case current.State == IncidentPending && now.Sub(current.ActiveSince) >= current.For:
+		current.State = IncidentFiring
+		notify = true
+	case current.State == IncidentFiring && m.renotify > 0 && now.Sub(current.LastNotified) >= m.renotify:
+		notify = true
+	}
+
+	if notify {
+		current.LastNotified = now
+	}
+	fired := *current
+	m.mu.Unlock()
+
+	if notify {
+		m.notify(fired)
+	}
+}
+
+// Resolve marks the incident as no longer active. Listeners are notified only if the incident was firing.
+func (m *IncidentManager) Resolve(id string) {
+	m.mu.Lock()
+	current, ok := m.incidents[id]
+	if ok {
+		delete(m.incidents, id)
+	}
+	m.mu.Unlock()
+
+	if !ok || current.State != IncidentFiring {
+		return
+	}
+
+	current.State = IncidentResolved
+	m.notify(*current)
+}
+
+// Notify notifies the listeners of a one-off incident that is not tracked and never resolved.
+func (m *IncidentManager) Notify(incident Incident) {
+	incident.State = IncidentFiring
+	m.notify(incident)
+}
+
+// notify calls the listeners without holding the lock as they may block on the network.
+func (m *IncidentManager) notify(incident Incident) {
+	log.Printf("Incident %s %s: %s\n", incident.ID, incident.State, incident.Message)
+	m.mu.Lock()
+	listeners := m.listeners
+	m.mu.Unlock()
+
+	for _, l := range listeners {
+		if il, ok := l.(IncidentListener); ok {
+			il.NotifyIncident(incident)
+			continue
+		}
+
+		severity := incident.Severity
+		if incident.State == IncidentResolved {
+			severity = Info
+		}
+		l.Notify(severity, incident.String())
+	}
+}
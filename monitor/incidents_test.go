@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingListener records the notifications as "severity: message".
+type recordingListener struct {
+	notified []string
+}
+
+func (l *recordingListener) Start(ctx context.Context) {}
+
+func (l *recordingListener) Notify(severity Severity, message string) {
+	l.notified = append(l.notified, fmt.Sprintf("%s: %s", severity, message))
+}
+
+func (l *recordingListener) SendMessage(message string) {}
+
+// incidentRecorder records the incidents as "state severity id".
+type incidentRecorder struct {
+	recordingListener
+}
+
+func (l *incidentRecorder) NotifyIncident(incident Incident) {
+	l.notified = append(l.notified, fmt.Sprintf("%s %s %s", incident.State, incident.Severity, incident.ID))
+}
+
+type incidentStep func(m *IncidentManager)
+
+func fire(incident Incident) incidentStep {
+	return func(m *IncidentManager) { m.Fire(incident) }
+}
+
+func resolve(id string) incidentStep {
+	return func(m *IncidentManager) { m.Resolve(id) }
+}
+
+func notify(incident Incident) incidentStep {
+	return func(m *IncidentManager) { m.Notify(incident) }
+}
+
+// age moves the incident back in time as if it was fired and last notified d ago.
+func age(id string, d time.Duration) incidentStep {
+	return func(m *IncidentManager) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if i, ok := m.incidents[id]; ok {
+			i.ActiveSince = i.ActiveSince.Add(-d)
+			i.LastNotified = i.LastNotified.Add(-d)
+		}
+	}
+}
+
+func TestIncidentManager(t *testing.T) {
+	down := Incident{ID: "node/down", Source: "node1", Severity: Alert, Message: "down"}
+	slow := Incident{ID: "node/slow", Severity: Warn, Message: "slow", For: 10 * time.Minute}
+
+	tests := []struct {
+		name     string
+		renotify time.Duration
+		steps    []incidentStep
+		want     []string
+	}{
+		{
+			name:  "fires without for",
+			steps: []incidentStep{fire(down)},
+			want:  []string{"Alert: [node1] down"},
+		},
+		{
+			name:  "notified once while firing",
+			steps: []incidentStep{fire(down), fire(down), fire(down)},
+			want:  []string{"Alert: [node1] down"},
+		},
+		{
+			name:  "resolved with info",
+			steps: []incidentStep{fire(down), resolve(down.ID)},
+			want:  []string{"Alert: [node1] down", "Info: [node1] Resolved: down"},
+		},
+		{
+			name:  "pending until for elapses",
+			steps: []incidentStep{fire(slow), age(slow.ID, 5*time.Minute), fire(slow)},
+		},
+		{
+			name:  "fires after for",
+			steps: []incidentStep{fire(slow), age(slow.ID, 10*time.Minute), fire(slow)},
+			want:  []string{"Warn: slow"},
+		},
+		{
+			name:  "pending resolved silently",
+			steps: []incidentStep{fire(slow), resolve(slow.ID)},
+		},
+		{
+			name:  "pending again after resolve",
+			steps: []incidentStep{fire(slow), age(slow.ID, 10*time.Minute), resolve(slow.ID), fire(slow)},
+		},
+		{
+			name:  "unknown resolved silently",
+			steps: []incidentStep{resolve(down.ID)},
+		},
+		{
+			name:     "renotified after interval",
+			renotify: time.Hour,
+			steps:    []incidentStep{fire(down), fire(down), age(down.ID, time.Hour), fire(down), fire(down)},
+			want:     []string{"Alert: [node1] down", "Alert: [node1] down"},
+		},
+		{
+			name:  "not renotified when disabled",
+			steps: []incidentStep{fire(down), age(down.ID, 24*time.Hour), fire(down)},
+			want:  []string{"Alert: [node1] down"},
+		},
+		{
+			name:  "latest severity notified",
+			steps: []incidentStep{fire(slow), age(slow.ID, time.Hour), fire(Incident{ID: slow.ID, Severity: Alert, Message: "slower"})},
+			want:  []string{"Alert: slower"},
+		},
+		{
+			name:  "one-off not tracked",
+			steps: []incidentStep{notify(slow), notify(slow), resolve(slow.ID)},
+			want:  []string{"Warn: slow", "Warn: slow"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &recordingListener{}
+			m := NewIncidentManager(tt.renotify, []Listener{l})
+			for _, step := range tt.steps {
+				step(m)
+			}
+
+			if !reflect.DeepEqual(l.notified, tt.want) {
+				t.Errorf("notified = %q, want %q", l.notified, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncidentManagerIncidentListener(t *testing.T) {
+	l := &incidentRecorder{}
+	m := NewIncidentManager(0, []Listener{l})
+	m.Fire(Incident{ID: "a", Severity: Warn, Message: "a"})
+	m.Resolve("a")
+
+	want := []string{"firing Warn a", "resolved Warn a"}
+	if !reflect.DeepEqual(l.notified, want) {
+		t.Errorf("notified = %q, want %q", l.notified, want)
+	}
+}
+
+func TestIncidentManagerConfigure(t *testing.T) {
+	old, current := &recordingListener{}, &recordingListener{}
+	m := NewIncidentManager(0, []Listener{old})
+	m.Fire(Incident{ID: "a", Severity: Warn, Message: "a"})
+
+	m.Configure(time.Hour, []Listener{current})
+	m.Fire(Incident{ID: "a", Severity: Warn, Message: "a"})
+	m.Resolve("a")
+
+	if want := []string{"Warn: a"}; !reflect.DeepEqual(old.notified, want) {
+		t.Errorf("old notified = %q, want %q", old.notified, want)
+	}
+
+	if want := []string{"Info: Resolved: a"}; !reflect.DeepEqual(current.notified, want) {
+		t.Errorf("current notified = %q, want %q", current.notified, want)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type journalEntry struct {
+	Cursor  string `json:"__CURSOR"`
+	Message string `json:"MESSAGE"`
+	// Realtime is the microseconds since epoch the entry was logged at.
+	Realtime string `json:"__REALTIME_TIMESTAMP"`
+}
+
+func (e journalEntry) Time() time.Time {
+	us, err := strconv.ParseInt(e.Realtime, 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+
+	return time.Unix(0, us*int64(time.Microsecond))
+}
+
+func parseJournalEntry(l []byte) (journalEntry, error) {
+	var entry journalEntry
+	return entry, json.Unmarshal(l, &entry)
+}
+
+// JournaldFollower follows the journald logs of a unit.
+// The cursor of the last processed entry is saved to the cursor file so the follower
+// resumes from there after a restart.
+type JournaldFollower struct {
+	unit       string
+	cursorPath string
+
+	mu     sync.Mutex
+	cursor string
+}
+
+func NewJournaldFollower(unit, cursorPath string) *JournaldFollower {
+	return &JournaldFollower{
+		unit:       unit,
+		cursorPath: cursorPath,
+	}
+}
+
+// Start follows the logs until the ctx is cancelled. journalctl is restarted if it exits.
+func (j *JournaldFollower) Start(ctx context.Context, handle func(line LogLine)) {
+	log.Printf("Following journald logs of %s...\n", j.unit)
+	j.loadCursor()
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		j.saveCursor()
+	}()
+
+	go func() {
+		tick := time.NewTicker(10 * time.Second)
+		defer tick.Stop()
+		saved := j.getCursor()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				cursor := j.getCursor()
+				if cursor == saved {
+					continue
+				}
+
+				j.saveCursor()
+				saved = cursor
+			}
+		}
+	}()
+
+	restartOnExit(ctx, fmt.Sprintf("journald follower of %s", j.unit), func(ctx context.Context) error {
+		return j.follow(ctx, handle)
+	})
+}
+
+func (j *JournaldFollower) follow(ctx context.Context, handle func(line LogLine)) error {
+	args := []string{"-u", j.unit, "-o", "json", "-f", "--no-pager"}
+	cursor := j.getCursor()
+	if cursor != "" {
+		args = append(args, "--after-cursor", cursor)
+	} else {
+		args = append(args, "--since", fmt.Sprintf("-%dhours", int(validatorStatsWindow.Hours())))
+	}
+
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := parseJournalEntry(scanner.Bytes())
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		handle(LogLine{Time: entry.Time(), Message: entry.Message})
+		j.setCursor(entry.Cursor)
+	}
+
+	if scanErr := scanner.Err(); scanErr != nil {
+		// unblock the journalctl output and let it exit
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("failed to read journal: %w", scanErr)
+	}
+
+	err = cmd.Wait()
+	if strings.Contains(stderr.String(), "cursor") {
+		// cursor is no longer in the journal. Start over.
+		j.setCursor("")
+	}
+
+	return fmt.Errorf("%v: %v", err, stderr.String())
+}
+
+func (j *JournaldFollower) getCursor() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.cursor
+}
+
+func (j *JournaldFollower) setCursor(cursor string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cursor = cursor
+}
+
+func (j *JournaldFollower) loadCursor() {
+	d, err := ioutil.ReadFile(j.cursorPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("failed to read journald cursor of %s: %v\n", j.unit, err)
+		}
+		return
+	}
+
+	j.setCursor(strings.TrimSpace(string(d)))
+}
+
+func (j *JournaldFollower) saveCursor() {
+	cursor := j.getCursor()
+	if cursor == "" {
+		return
+	}
+
+	err := writeFileAtomic(j.cursorPath, []byte(cursor))
+	if err != nil {
+		log.Printf("failed to save journald cursor of %s: %v\n", j.unit, err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const defaultLogRuleMessage = "{{.Rule}}: {{.Count}} matching log lines in {{.Window}}. Last: {{.Line}}"
+
+var defaultLogRuleWindow = Duration{10 * time.Minute}
+
+// LogRule notifies when the node logs a line matching the Pattern.
+type LogRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	// Severity of the notification
+	Severity Severity `json:"severity"`
+	// Threshold is the number of matches within the Window required to notify. Defaults to 1.
+	// The rule notifies at most once per Window. Window defaults to 10m.
+	Threshold int      `json:"threshold"`
+	Window    Duration `json:"window"`
+	// Message is a text/template rendered with .Node, .Rule, .Line, .Match, .Count and .Window
+	Message string `json:"message"`
+}
+
+// defaultLogRules are used when the config doesn't define any log rules.
+func defaultLogRules() []LogRule {
+	window := defaultLogRuleWindow
+	return []LogRule{
+		{Name: "Essential task failed", Pattern: `Essential task .* failed`, Severity: Alert, Window: window},
+		{Name: "Node panicked", Pattern: `panicked at`, Severity: Alert, Window: window},
+		{Name: "Peer banned", Pattern: `Banned peer`, Severity: Warn, Threshold: 10, Window: window},
+		{Name: "GRANDPA voter error", Pattern: `GRANDPA voter error`, Severity: Alert, Window: window},
+		{Name: "Block import error", Pattern: `Error importing block`, Severity: Warn, Threshold: 5, Window: window},
+	}
+}
+
+type compiledLogRule struct {
+	LogRule
+	re   *regexp.Regexp
+	tmpl *template.Template
+}
+
+func compileLogRules(rules []LogRule) ([]compiledLogRule, error) {
+	res := make([]compiledLogRule, 0, len(rules))
+	for i, r := range rules {
+		if r.Name == "" {
+			r.Name = r.Pattern
+		}
+
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("log rule %d: %w", i, err)
+		}
+
+		if r.Message == "" {
+			r.Message = defaultLogRuleMessage
+		}
+
+		tmpl, err := template.New(r.Name).Parse(r.Message)
+		if err != nil {
+			return nil, fmt.Errorf("log rule %d: %w", i, err)
+		}
+
+		if r.Threshold < 1 {
+			r.Threshold = 1
+		}
+
+		if r.Window.Duration <= 0 {
+			r.Window = defaultLogRuleWindow
+		}
+
+		res = append(res, compiledLogRule{LogRule: r, re: re, tmpl: tmpl})
+	}
+
+	return res, nil
+}
+
+// logRuleData is the data the log rule message is rendered with.
+type logRuleData struct {
+	Node   string
+	Rule   string
+	Line   string
+	Match  []string
+	Count  int
+	Window Duration
+}
+
+// LogRuleMatcher matches the log lines of a node against the log rules.
+type LogRuleMatcher struct {
+	node      NodeConfig
+	rules     []compiledLogRule
+	incidents *IncidentManager
+
+	mu sync.Mutex
+	// matches are the times of the matches within the window per rule
+	matches [][]time.Time
+	// notified is the last time each rule notified
+	notified []time.Time
+}
+
+func NewLogRuleMatcher(node NodeConfig, rules []LogRule, incidents *IncidentManager) (*LogRuleMatcher, error) {
+	compiled, err := compileLogRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogRuleMatcher{
+		node:      node,
+		rules:     compiled,
+		incidents: incidents,
+		matches:   make([][]time.Time, len(compiled)),
+		notified:  make([]time.Time, len(compiled)),
+	}, nil
+}
+
+// Handle checks the line against every rule.
+func (m *LogRuleMatcher) Handle(line LogLine) {
+	for _, incident := range m.match(line) {
+		m.incidents.Notify(incident)
+	}
+}
+
+// match returns the incidents of the rules the line triggers.
+func (m *LogRuleMatcher) match(line LogLine) []Incident {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var res []Incident
+	for i, r := range m.rules {
+		// lines replayed from before the window are stale
+		if time.Since(line.Time) > r.Window.Duration {
+			continue
+		}
+
+		match := r.re.FindStringSubmatch(line.Message)
+		if match == nil {
+			continue
+		}
+
+		// keep only the matches within the window
+		matches := m.matches[i][:0]
+		for _, t := range m.matches[i] {
+			if line.Time.Sub(t) < r.Window.Duration {
+				matches = append(matches, t)
+			}
+		}
+		matches = append(matches, line.Time)
+		m.matches[i] = matches
+
+		if len(matches) < r.Threshold {
+			continue
+		}
+
+		if !m.notified[i].IsZero() && line.Time.Sub(m.notified[i]) < r.Window.Duration {
+			continue
+		}
+
+		var buf bytes.Buffer
+		err := r.tmpl.Execute(&buf, logRuleData{
+			Node:   m.node.Name,
+			Rule:   r.Name,
+			Line:   line.Message,
+			Match:  match,
+			Count:  len(matches),
+			Window: r.Window,
+		})
+		if err != nil {
+			log.Printf("failed to render log rule %s message: %v\n", r.Name, err)
+			buf.Reset()
+			buf.WriteString(fmt.Sprintf("%s: %s", r.Name, line.Message))
+		}
+
+		m.notified[i] = line.Time
+		res = append(res, Incident{
+			ID:       nodeIncidentID(m.node, fmt.Sprintf("log/%d", i)),
+			Source:   m.node.Name,
+			Severity: r.Severity,
+			Message:  buf.String(),
+			Details: map[string]interface{}{
+				"pattern": r.Pattern,
+				"line":    line.Message,
+				"time":    line.Time,
+			},
+		})
+	}
+
+	return res
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogRuleMatcher(t *testing.T) {
+	// line offsets are from now so the lines are not stale unless made so
+	type line struct {
+		at      time.Duration
+		message string
+	}
+
+	tests := []struct {
+		name  string
+		rule  LogRule
+		lines []line
+		want  []string
+	}{
+		{
+			name:  "notified on match",
+			rule:  LogRule{Pattern: `panicked at '(.+)'`, Message: "{{.Rule}}: {{index .Match 1}}"},
+			lines: []line{{0, "Thread 'main' panicked at 'boom'"}, {time.Second, "Imported #1"}},
+			want:  []string{"panicked at '(.+)': boom"},
+		},
+		{
+			name: "below threshold",
+			rule: LogRule{Pattern: "Banned peer", Threshold: 3},
+			lines: []line{
+				{0, "Banned peer a"}, {time.Second, "Banned peer b"}, {2 * time.Second, "Imported #1"},
+			},
+		},
+		{
+			name: "threshold reached",
+			rule: LogRule{Pattern: "Banned peer", Threshold: 3, Message: "{{.Count}} in {{.Window}}: {{.Line}}"},
+			lines: []line{
+				{0, "Banned peer a"}, {time.Second, "Banned peer b"}, {2 * time.Second, "Banned peer c"},
+			},
+			want: []string{"3 in 10m0s: Banned peer c"},
+		},
+		{
+			name: "matches outside window dropped",
+			rule: LogRule{Pattern: "Banned peer", Threshold: 2, Window: Duration{time.Minute}},
+			lines: []line{
+				{0, "Banned peer a"}, {time.Minute, "Banned peer b"}, {2 * time.Minute, "Banned peer c"},
+			},
+		},
+		{
+			name: "notified once per window",
+			rule: LogRule{Pattern: "Banned peer", Window: Duration{time.Minute}, Message: "{{.Line}}"},
+			lines: []line{
+				{0, "Banned peer a"}, {30 * time.Second, "Banned peer b"}, {time.Minute, "Banned peer c"},
+			},
+			want: []string{"Banned peer a", "Banned peer c"},
+		},
+		{
+			name:  "stale lines skipped",
+			rule:  LogRule{Pattern: "Banned peer", Window: Duration{time.Minute}},
+			lines: []line{{-2 * time.Minute, "Banned peer a"}},
+		},
+		{
+			name:  "default message",
+			rule:  LogRule{Name: "Peer banned", Pattern: "Banned peer"},
+			lines: []line{{0, "Banned peer a"}},
+			want:  []string{"Peer banned: 1 matching log lines in 10m0s. Last: Banned peer a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewLogRuleMatcher(NodeConfig{Name: "node1"}, []LogRule{tt.rule}, nil)
+			if err != nil {
+				t.Fatalf("NewLogRuleMatcher() error = %v", err)
+			}
+
+			now := time.Now()
+			var got []string
+			for _, l := range tt.lines {
+				for _, incident := range m.match(LogLine{Time: now.Add(l.at), Message: l.message}) {
+					got = append(got, incident.Message)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("notified = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileLogRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule LogRule
+	}{
+		{name: "invalid pattern", rule: LogRule{Pattern: "panicked at ("}},
+		{name: "invalid message", rule: LogRule{Pattern: "panicked", Message: "{{.Line"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileLogRules([]LogRule{tt.rule}); err == nil {
+				t.Errorf("compileLogRules() error = nil, want an error")
+			}
+		})
+	}
+}
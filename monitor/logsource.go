@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	LogSourceJournald = "journald"
+	LogSourceFile     = "file"
+	LogSourceDocker   = "docker"
+)
+
+// LogLine is a single log message of a node.
+type LogLine struct {
+	Time    time.Time
+	Message string
+}
+
+// LogSource streams the node logs to handle until the ctx is cancelled.
+type LogSource interface {
+	Start(ctx context.Context, handle func(line LogLine))
+}
+
+// NewLogSource returns the log source of the node as configured.
+func NewLogSource(node NodeConfig, dataDir string) (LogSource, error) {
+	switch node.Log.Type {
+	case LogSourceJournald, "":
+		cursorPath := statePath(dataDir, fmt.Sprintf("%s.journald.cursor", node.Name))
+		return NewJournaldFollower(node.Log.Unit, cursorPath), nil
+	case LogSourceFile:
+		if node.Log.Path == "" {
+			return nil, fmt.Errorf("node %s: log path is required", node.Name)
+		}
+		return NewFileTail(node.Log.Path), nil
+	case LogSourceDocker:
+		if node.Log.Container == "" {
+			return nil, fmt.Errorf("node %s: log container is required", node.Name)
+		}
+		return NewDockerLogs(node.Log.Container), nil
+	}
+
+	return nil, fmt.Errorf("node %s: unknown log source type %q", node.Name, node.Log.Type)
+}
+
+// restartOnExit runs follow until the ctx is cancelled and restarts it a minute after it exits.
+func restartOnExit(ctx context.Context, name string, follow func(ctx context.Context) error) {
+	for {
+		err := follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("%s stopped: %v. Restarting in a min...\n", name, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+var ansiRegex = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// stripANSI removes the terminal colour codes from the log line.
+func stripANSI(s string) string {
+	return ansiRegex.ReplaceAllString(s, "")
+}
+
+// FileTail follows a log file. Rotation is detected when the file at path is replaced or truncated.
+type FileTail struct {
+	path     string
+	interval time.Duration
+}
+
+func NewFileTail(path string) *FileTail {
+	return &FileTail{path: path, interval: time.Second}
+}
+
+func (f *FileTail) Start(ctx context.Context, handle func(line LogLine)) {
+	log.Printf("Following log file %s...\n", f.path)
+	restartOnExit(ctx, fmt.Sprintf("tail of %s", f.path), func(ctx context.Context) error {
+		return f.follow(ctx, handle)
+	})
+}
+
+func (f *FileTail) follow(ctx context.Context, handle func(line LogLine)) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+	}()
+
+	// start from the end. Older lines are already in the past.
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	var partial string
+	tick := time.NewTicker(f.interval)
+	defer tick.Stop()
+	for {
+		// drain the file
+		for {
+			l, err := reader.ReadString('\n')
+			offset += int64(len(l))
+			if err != nil {
+				// incomplete line is completed on the next read
+				partial += l
+				break
+			}
+
+			handle(LogLine{Time: time.Now(), Message: stripANSI(strings.TrimRight(partial+l, "\r\n"))})
+			partial = ""
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+
+		rotated, truncated, err := f.changed(file, offset)
+		if err != nil {
+			// file might be mid rotation
+			continue
+		}
+
+		switch {
+		case rotated:
+			// read the remaining lines of the old file before switching
+			rest, _ := ioutil.ReadAll(reader)
+			for _, l := range strings.Split(strings.TrimRight(partial+string(rest), "\n"), "\n") {
+				if l != "" {
+					handle(LogLine{Time: time.Now(), Message: stripANSI(strings.TrimRight(l, "\r"))})
+				}
+			}
+			partial = ""
+
+			nf, err := os.Open(f.path)
+			if err != nil {
+				continue
+			}
+
+			file.Close()
+			file, offset, partial = nf, 0, ""
+			reader.Reset(file)
+		case truncated:
+			offset, err = file.Seek(0, io.SeekStart)
+			if err != nil {
+				return err
+			}
+			partial = ""
+			reader.Reset(file)
+		}
+	}
+}
+
+// changed reports if the file at path is no longer the opened file or if the opened file got truncated.
+func (f *FileTail) changed(file *os.File, offset int64) (rotated, truncated bool, err error) {
+	current, err := file.Stat()
+	if err != nil {
+		return false, false, err
+	}
+
+	latest, err := os.Stat(f.path)
+	if err != nil {
+		return false, false, err
+	}
+
+	if !os.SameFile(current, latest) {
+		return true, false, nil
+	}
+
+	return false, latest.Size() < offset, nil
+}
+
+// DockerLogs follows the logs of a container with `docker logs -f`.
+type DockerLogs struct {
+	container string
+}
+
+func NewDockerLogs(container string) *DockerLogs {
+	return &DockerLogs{container: container}
+}
+
+func (d *DockerLogs) Start(ctx context.Context, handle func(line LogLine)) {
+	log.Printf("Following docker logs of %s...\n", d.container)
+	since := time.Now().Add(-validatorStatsWindow)
+	restartOnExit(ctx, fmt.Sprintf("docker logs of %s", d.container), func(ctx context.Context) error {
+		return d.follow(ctx, since, func(line LogLine) {
+			// resume after the last line on restart
+			since = line.Time.Add(time.Nanosecond)
+			handle(line)
+		})
+	})
+}
+
+func (d *DockerLogs) follow(ctx context.Context, since time.Time, handle func(line LogLine)) error {
+	cmd := exec.CommandContext(ctx, "docker", "logs", "-f", "--timestamps",
+		"--since", since.UTC().Format(time.RFC3339Nano), d.container)
+	// node logs are on stderr
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		handle(parseDockerLine(scanner.Text()))
+	}
+
+	if err := scanner.Err(); err != nil {
+		// unblock the docker output and let it exit
+		cmd.Process.Kill()
+		pr.CloseWithError(err)
+	}
+
+	err := <-waitErr
+	if err == nil {
+		err = fmt.Errorf("container %s logs ended", d.container)
+	}
+	return err
+}
+
+// parseDockerLine splits the timestamp added by --timestamps from the message.
+func parseDockerLine(l string) LogLine {
+	line := LogLine{Time: time.Now(), Message: l}
+	split := strings.SplitN(l, " ", 2)
+	if len(split) != 2 {
+		return line
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, split[0])
+	if err != nil {
+		return line
+	}
+
+	line.Time, line.Message = t, stripANSI(split[1])
+	return line
+}
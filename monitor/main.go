@@ -4,89 +4,148 @@ import (
 	"context"
 	"flag"
 	"log"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/octago/sflags/gen/gflag"
 )
 
-type Config struct {
-	Name             string        `json:"name"`
-	MonitorFrequency time.Duration `json:"monitor_frequency"`
+func main() {
+	configPath := flag.String("config", "", "Path to the JSON or YAML config file")
+	config := defaultConfig()
+	err := gflag.ParseToDef(&config)
+	if err != nil {
+		panic(err)
+	}
+	flag.Parse()
 
-	TelegramKey         string `json:"telegram_key"`
-	TelegramChatID      string `json:"telegram_chat_id"`
-	TelegramSeverity    int    `json:"telegram_severity"`
-	TelegramBotUsername string `json:"telegram_bot_username"`
+	// flags set explicitly take precedence over the config file and environment on every (re)load
+	overrides := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+
+		overrides[f.Name] = f.Value.String()
+	})
+
+	config, err = LoadConfig(*configPath, overrides)
+	if err != nil {
+		log.Fatalln("Failed to load config:", err)
+	}
 
-	PagerdutyAPIKey string `json:"pagerduty_api_key"`
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 
-	Payout struct {
-		Stash        string `json:"stash"`
-		HotWalletURI string `json:"hot_wallet_uri"`
-		Decimals     int    `json:"decimals"`
-		Unit         string `json:"unit"`
-	} `json:"payout"`
+	n := &notifiers{incidents: NewIncidentManager(0, nil)}
+	ctx, cancel := context.WithCancel(context.Background())
+	start(ctx, config, n)
+	for range reload {
+		log.Println("Reloading config...")
+		nc, err := LoadConfig(*configPath, overrides)
+		if err != nil {
+			log.Println("Failed to reload config. Continuing with the current one:", err)
+			continue
+		}
+
+		cancel()
+		ctx, cancel = context.WithCancel(context.Background())
+		start(ctx, nc, n)
+	}
 }
 
-func (c Config) IsTelegramBotEnabled() bool {
-	return c.TelegramKey != "" && c.TelegramChatID != ""
+// notifiers outlive the config reloads so firing incidents are neither notified again nor left unresolved.
+type notifiers struct {
+	incidents *IncidentManager
+	pagerduty *Pagerduty
 }
 
-func main() {
-	config := Config{
-		MonitorFrequency: time.Minute * 5,
-		Name:             "Monitor",
-	}
-	config.Payout.Decimals = 1
-	err := gflag.ParseToDef(&config)
-	if err != nil {
-		panic(err)
-	}
-	flag.Parse()
+// start runs the listeners, monitors and the accountant for the config until the ctx is cancelled.
+func start(ctx context.Context, config Config, n *notifiers) {
+	nodes := NewNodes(config)
 
 	var listeners []Listener
 	var telegram *Telegram
 	if config.IsTelegramBotEnabled() {
-		telegram = NewTelegramBot(config)
+		telegram = NewTelegramBot(config, nodes)
 		listeners = append(listeners, telegram)
 	} else {
 		log.Println("Telegram bot disabled.")
 	}
 
+	for _, listener := range listeners {
+		go listener.Start(ctx)
+	}
+
 	if config.PagerdutyAPIKey != "" {
-		listeners = append(listeners, NewPagerduty(config.Name, config.PagerdutyAPIKey))
+		if n.pagerduty == nil {
+			n.pagerduty = NewPagerduty(config)
+			// events queued before a reload are still sent
+			go n.pagerduty.Start(context.Background())
+		} else {
+			n.pagerduty.Configure(config)
+		}
+		listeners = append(listeners, n.pagerduty)
 	} else {
 		log.Println("Pagerduty bot disabled.")
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	incidents := n.incidents
+	incidents.Configure(config.RenotifyInterval.Duration, listeners)
+	go InitMonitor(ctx, config, nodes, incidents)
 
-	for _, listener := range listeners {
-		go listener.Start(ctx)
+	if config.Payout.Stash == "" {
+		log.Println("Chain monitor disabled.")
+		return
 	}
 
-	go InitMonitor(ctx, config, listeners)
+	conn := NewConnection(config.ChainEndpoints(), incidents)
+	go conn.Start(ctx)
+	go startChain(ctx, config, conn, incidents, listeners, telegram)
+}
+
+// startChain runs the chain monitor and the accountant once connected to the chain.
+func startChain(ctx context.Context, config Config, conn *Connection, incidents *IncidentManager,
+	listeners []Listener, telegram *Telegram) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-conn.Ready():
+	}
+
+	// handlers are registered before the bus starts so no block is missed
+	bus := NewEventBus(conn, incidents, config.FinalizedEvents, statePath(config.DataDir, "events.block"))
+	defer func() {
+		go bus.Start(ctx)
+	}()
+
+	chain, err := NewChainMonitor(conn, config, incidents, listeners)
+	if err != nil {
+		log.Println("Failed to create chain monitor", err)
+		return
+	}
 
-	if config.Payout.Stash != "" || config.Payout.HotWalletURI != "" {
+	if telegram != nil {
+		telegram.SetChainMonitor(chain)
+	}
+	chain.Start(ctx, bus)
+
+	if config.Payout.HotWalletURI != "" {
 		log.Println("Starting Accountant...")
-		acc, err := NewAccountant(config.Payout.Stash,
-			config.Payout.HotWalletURI,
-			config.Payout.Unit,
-			config.Payout.Decimals, listeners)
+		acc, err := NewAccountant(conn, config, listeners)
 		if err != nil {
 			log.Println("Failed to create accountant", err)
+			return
 		}
 
 		if telegram != nil {
 			telegram.SetAccountant(acc)
 		}
 
-		err = acc.Start(ctx)
+		err = acc.Start(ctx, bus)
 		if err != nil {
 			log.Println("Failed to start accountant", err)
 		}
 	}
-
-	select {}
 }
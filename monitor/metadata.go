@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"sync"
+
+	gsrpc "github.com/centrifuge/go-substrate-rpc-client"
+	"github.com/centrifuge/go-substrate-rpc-client/types"
+	"github.com/centrifuge/go-substrate-rpc-client/xxhash"
+)
+
+// ChainAPI is the RPC api of the connected endpoint with the metadata cache of the chain.
+type ChainAPI struct {
+	*gsrpc.SubstrateAPI
+	metadata *MetadataCache
+}
+
+// LatestMetadata returns the metadata of the latest runtime.
+func (a *ChainAPI) LatestMetadata() (*types.Metadata, error) {
+	return a.metadata.Latest(a.SubstrateAPI)
+}
+
+// MetadataAt returns the metadata of the runtime at the block.
+func (a *ChainAPI) MetadataAt(block types.Hash) (*types.Metadata, error) {
+	return a.metadata.At(a.SubstrateAPI, block)
+}
+
+// EventsMetadata returns the metadata to decode the events of the block with. Events are emitted by
+// the runtime of the parent block, so the block upgrading the runtime still emits the old runtime events.
+func (a *ChainAPI) EventsMetadata(block types.Hash) (*types.Metadata, error) {
+	header, err := a.RPC.Chain.GetHeader(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.MetadataAt(header.ParentHash)
+}
+
+// CheckRuntimeUpgrade refreshes the latest metadata if the runtime was upgraded since the last check.
+func (a *ChainAPI) CheckRuntimeUpgrade() error {
+	return a.metadata.CheckUpgrade(a.SubstrateAPI)
+}
+
+// MetadataCache keeps the runtime metadata by spec version so it is fetched once per runtime
+// instead of on every storage read. The latest metadata is refreshed on a runtime upgrade.
+type MetadataCache struct {
+	mu       sync.Mutex
+	versions map[types.U32]*types.Metadata
+	latest   *types.Metadata
+	// generation is increased on every invalidation so a Latest started before is not cached
+	generation uint64
+	// upgrade is the last seen encoded System.LastRuntimeUpgrade
+	upgrade []byte
+}
+
+func NewMetadataCache() *MetadataCache {
+	return &MetadataCache{versions: make(map[types.U32]*types.Metadata)}
+}
+
+// Latest returns the metadata of the latest runtime.
+func (c *MetadataCache) Latest(api *gsrpc.SubstrateAPI) (*types.Metadata, error) {
+	c.mu.Lock()
+	meta, generation := c.latest, c.generation
+	c.mu.Unlock()
+	if meta != nil {
+		return meta, nil
+	}
+
+	hash, err := api.RPC.Chain.GetBlockHashLatest()
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err = c.At(api, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.latest = meta
+	}
+	c.mu.Unlock()
+	return meta, nil
+}
+
+// At returns the metadata of the runtime at the block.
+func (c *MetadataCache) At(api *gsrpc.SubstrateAPI, block types.Hash) (*types.Metadata, error) {
+	rv, err := api.RPC.State.GetRuntimeVersion(block)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	meta, ok := c.versions[rv.SpecVersion]
+	c.mu.Unlock()
+	if ok {
+		return meta, nil
+	}
+
+	meta, err = api.RPC.State.GetMetadata(block)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.versions[rv.SpecVersion] = meta
+	c.mu.Unlock()
+	return meta, nil
+}
+
+// Invalidate drops the latest metadata so the next Latest fetches it again.
+func (c *MetadataCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latest = nil
+	c.generation++
+}
+
+// CheckUpgrade invalidates the latest metadata if System.LastRuntimeUpgrade changed since the last check.
+func (c *MetadataCache) CheckUpgrade(api *gsrpc.SubstrateAPI) error {
+	// key is built without the metadata as the storage is plain
+	key := append(xxhash.New128([]byte("System")).Sum(nil), xxhash.New128([]byte("LastRuntimeUpgrade")).Sum(nil)...)
+	raw, err := api.RPC.State.GetStorageRawLatest(key)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if bytes.Equal(c.upgrade, *raw) {
+		return nil
+	}
+
+	if c.upgrade != nil {
+		log.Println("Runtime upgraded. Refreshing metadata...")
+	}
+	c.upgrade, c.latest = *raw, nil
+	c.generation++
+	return nil
+}
@@ -1,14 +1,11 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
-	"regexp"
 	"strings"
 	"time"
 )
@@ -25,164 +22,169 @@ const (
 	ErrorEmoji = "❌"
 )
 
-type Listener interface {
-	Start(ctx context.Context)
-	Notify(severity Severity, message string)
-	SendMessage(message string)
-}
-
-func InitMonitor(ctx context.Context, config Config, listeners []Listener) {
-	log.Println("Starting monitoring....")
-	log.Printf("Checking every %s...\n", config.MonitorFrequency)
-
-	tick := time.NewTicker(config.MonitorFrequency)
-	var prevMetrics Metrics
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Stopping monitor...")
-			return
-		case <-tick.C:
-			current, err := FetchMetrics()
-			if err != nil {
-				notifyError(err.Error(), listeners)
-				continue
-			}
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "Info"
+	case Warn:
+		return "Warn"
+	case Alert:
+		return "Alert"
+	}
 
-			if current.IsMajorSyncing {
-				notifyWarn("Node is in Major Sync", listeners)
-				continue
-			}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
 
-			if prevMetrics.BlockHeight.Finalized != nil &&
-				current.BlockHeight.Finalized.Cmp(&prevMetrics.BlockHeight.Finalized.Int) <= 0 {
-				notifyError(
-					fmt.Sprintf("Node hasn't finalised new block since `%s`", prevMetrics.BlockHeight.Finalized.String()),
-					listeners)
-				continue
-			}
+// ParseSeverity parses the severity name. Error is accepted as an alias of Alert.
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "info":
+		return Info, nil
+	case "warn", "warning":
+		return Warn, nil
+	case "alert", "error":
+		return Alert, nil
+	}
 
-			if current.Peers < 1 {
-				notifyError(
-					"Node has 0 peers",
-					listeners)
-				continue
-			}
+	return 0, fmt.Errorf("unknown severity: %q", s)
+}
 
-			if !current.ValidatorStats.IsValidating {
-				if prevMetrics.ValidatorStats.IsValidating {
-					notifyWarn(
-						fmt.Sprintf("Node didn't produce blocks in last %d minutes",
-							int(config.MonitorFrequency.Minutes())),
-						listeners,
-					)
-					continue
-				}
-			}
+// UnmarshalJSON accepts both the severity names and numbers.
+func (s *Severity) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		v, err := ParseSeverity(name)
+		if err != nil {
+			return err
+		}
 
-			if prevMetrics.ValidatorStats.IsValidating {
-				if current.ValidatorStats.LastProduced.Cmp(&prevMetrics.ValidatorStats.LastProduced.Int) <= 0 {
-					notifyWarn(
-						fmt.Sprintf("Node didn't produce blocks in last %d minutes",
-							int(config.MonitorFrequency.Minutes())),
-						listeners,
-					)
-					continue
-				}
-			}
+		*s = v
+		return nil
+	}
 
-			// all good here
-			notifyOk(listeners)
-			prevMetrics = current
-		}
+	var v int
+	if err := json.Unmarshal(data, &v); err != nil {
+		return errors.New("severity must be a name or a number")
 	}
-}
 
-func notifyOk(listeners []Listener) {
-	notify(Info, listeners, "Ok")
+	*s = Severity(v)
+	return nil
 }
 
-func notifyWarn(message string, listeners []Listener) {
-	notify(Warn, listeners, message)
+type Listener interface {
+	Start(ctx context.Context)
+	Notify(severity Severity, message string)
+	SendMessage(message string)
 }
 
-func notifyError(message string, listeners []Listener) {
-	notify(Alert, listeners, message)
+// Node is a monitored node with the aggregated stats of its logs.
+type Node struct {
+	NodeConfig
+	Stats *ValidatorStatsAggregator
+	logs  LogSource
 }
 
-func notify(severity Severity, listeners []Listener, msg string) {
-	for _, listener := range listeners {
-		listener.Notify(severity, msg)
+// NewNodes returns the configured nodes.
+func NewNodes(config Config) []*Node {
+	var nodes []*Node
+	for _, nc := range config.Nodes {
+		src, err := NewLogSource(nc, config.DataDir)
+		if err != nil {
+			log.Println(err)
+		}
+
+		nodes = append(nodes, &Node{
+			NodeConfig: nc,
+			Stats:      NewValidatorStatsAggregator(validatorStatsWindow),
+			logs:       src,
+		})
 	}
-}
 
-type ValidatorStats struct {
-	IsValidating   bool  `json:"is_validating"`
-	BlocksProduced int   `json:"blocks_produced"`
-	LastProduced   *bint `json:"last_produced"`
+	return nodes
 }
 
-func fetchValidatorStats() (ValidatorStats, error) {
-	cmd := exec.Command(
-		"journalctl",
-		// TODO: make it configurable
-		"-u", "centrifuge",
-		"-o", "json",
-		"--since", "-4hours",
-		"--no-pager")
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	output, err := cmd.Output()
-	if err != nil {
-		return ValidatorStats{}, fmt.Errorf("%v: %v", err, stderr.String())
+// FollowLogs feeds the node logs to the stats and the handlers until the ctx is cancelled.
+func (n *Node) FollowLogs(ctx context.Context, handlers ...func(line LogLine)) {
+	if n.logs == nil {
+		return
 	}
 
-	scanner := bufio.NewScanner(bytes.NewReader(output))
-	var vs ValidatorStats
-	var c int
-	for scanner.Scan() {
-		l := strings.TrimSpace(scanner.Text())
-		nvs, err := parseValidatorLog(l)
-		if err != nil {
-			log.Println(err)
-			continue
+	n.logs.Start(ctx, func(line LogLine) {
+		n.Stats.Observe(line.Time, line.Message)
+		for _, h := range handlers {
+			h(line)
 		}
+	})
+}
 
-		if nvs.LastProduced == nil {
-			continue
+func InitMonitor(ctx context.Context, config Config, nodes []*Node, incidents *IncidentManager) {
+	log.Println("Starting monitoring....")
+	log.Printf("Checking every %s...\n", config.MonitorFrequency)
+	for _, node := range nodes {
+		logRules, err := NewLogRuleMatcher(node.NodeConfig, config.LogRules, incidents)
+		if err != nil {
+			log.Printf("Invalid log rules for node %s: %v\n", node.Name, err)
+			return
 		}
 
-		c++
-		vs = nvs
+		go node.FollowLogs(ctx, logRules.Handle)
+		go monitorNode(ctx, config, node, incidents)
 	}
+}
 
-	vs.BlocksProduced = c
-	return vs, nil
+// nodeIncident returns the incident for the check on the node.
+func nodeIncident(node NodeConfig, check string, severity Severity, message string) Incident {
+	return Incident{
+		ID:       nodeIncidentID(node, check),
+		Source:   node.Name,
+		Severity: severity,
+		Message:  message,
+	}
 }
 
-var valRegex = regexp.MustCompile(`🎁 Prepared block for proposing at ([0-9]+)`)
+func nodeIncidentID(node NodeConfig, check string) string {
+	return fmt.Sprintf("%s/%s", node.Name, check)
+}
 
-func parseValidatorLog(l string) (ValidatorStats, error) {
-	var message struct {
-		Cursor  string `json:"__CURSOR"`
-		Message string `json:"MESSAGE"`
+// monitorNode checks the node every MonitorFrequency and raises the incidents for the node.
+func monitorNode(ctx context.Context, config Config, node *Node, incidents *IncidentManager) {
+	log.Printf("Monitoring node %s...\n", node.Name)
+	rules, err := NewRuleEvaluator(node.NodeConfig, config.Rules)
+	if err != nil {
+		log.Printf("Invalid rules for node %s: %v\n", node.Name, err)
+		return
 	}
 
-	if err := json.Unmarshal([]byte(l), &message); err != nil {
-		return ValidatorStats{}, err
-	}
+	checks := NewChecks(config)
+	tick := time.NewTicker(config.MonitorFrequency.Duration)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping monitor for node %s...\n", node.Name)
+			return
+		case <-tick.C:
+			current, err := FetchMetrics(node)
+			if err != nil {
+				incidents.Fire(nodeIncident(node.NodeConfig, "metrics", Alert, err.Error()))
+				continue
+			}
+			incidents.Resolve(nodeIncidentID(node.NodeConfig, "metrics"))
 
-	res := valRegex.FindAllStringSubmatch(message.Message, -1)
-	var latest *bint
-	for _, s := range res {
-		if len(s) > 1 {
-			latest = mustBigInt(s[1])
+			rules.Evaluate(current.Families, incidents)
+
+			report := RunChecks(node.NodeConfig, checks, current)
+			log.Println(report)
+			for _, res := range report.Results {
+				if res.Healthy {
+					incidents.Resolve(nodeIncidentID(node.NodeConfig, res.Check))
+					continue
+				}
+
+				incident := nodeIncident(node.NodeConfig, res.Check, res.Severity, res.Message)
+				incident.Details = current
+				incidents.Fire(incident)
+			}
 		}
 	}
-
-	return ValidatorStats{
-		IsValidating: latest != nil,
-		LastProduced: latest,
-	}, nil
 }
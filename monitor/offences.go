@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/centrifuge/go-substrate-rpc-client/types"
+	"github.com/centrifuge/go-substrate-rpc-client/xxhash"
+)
+
+// EventOffencesOffence is emitted when an offence of the kind is reported at the time slot.
+// Applied is false if the offence is deferred.
+type EventOffencesOffence struct {
+	Phase          types.Phase
+	Kind           types.Bytes16
+	OpaqueTimeSlot types.Bytes
+	Applied        bool
+	Topics         []types.Hash
+}
+
+// EventImOnlineSomeOffline is emitted at the end of the session if validators were offline.
+type EventImOnlineSomeOffline struct {
+	Phase                types.Phase
+	IdentificationTuples []struct {
+		ValidatorID        types.AccountID
+		FullIdentification Exposure
+	}
+	Topics []types.Hash
+}
+
+// EventStakingChilled is emitted when the stash is chilled and no longer validates.
+type EventStakingChilled struct {
+	Phase  types.Phase
+	Stash  types.AccountID
+	Topics []types.Hash
+}
+
+// offenceDetails are the offender and the reporters of an offence report.
+type offenceDetails struct {
+	Offender struct {
+		Who      types.AccountID
+		Exposure Exposure
+	}
+	Reporters []types.AccountID
+}
+
+// UnappliedSlash is a deferred slash that can still be cancelled by governance.
+type UnappliedSlash struct {
+	Validator types.AccountID
+	Own       types.U128
+	Others    []struct {
+		Who    types.AccountID
+		Amount types.U128
+	}
+	Reporters []types.AccountID
+	Payout    types.U128
+}
+
+// offenceKind returns the readable kind. Example: im-online:offlin
+func offenceKind(kind types.Bytes16) string {
+	return strings.TrimRight(string(kind[:]), "\x00")
+}
+
+// handleOffenceEvents alerts on the offences, slashes, offline reports and chills of the stash.
+func (m *ChainMonitor) handleOffenceEvents(block types.Hash, events EventRecords) {
+	alert := func(check, msg string) {
+		m.incidents.Notify(Incident{
+			ID:       chainIncidentID(check),
+			Source:   chainSource,
+			Severity: Alert,
+			Message:  msg,
+			Details:  map[string]interface{}{"block": block.Hex()},
+		})
+	}
+
+	for _, e := range events.Offences_Offence {
+		offenders, err := fetchOffenders(m.conn.API(), e.Kind, e.OpaqueTimeSlot)
+		if err != nil {
+			log.Printf("failed to fetch offenders of %s offence: %v\n", offenceKind(e.Kind), err)
+			continue
+		}
+
+		for _, o := range offenders {
+			if o != m.stash {
+				continue
+			}
+
+			alert("offence", fmt.Sprintf("Offence %s reported against the stash in block %s. Applied: %t",
+				offenceKind(e.Kind), block.Hex(), e.Applied))
+		}
+	}
+
+	for _, e := range events.Staking_Slash {
+		if e.AccountID != m.stash {
+			continue
+		}
+
+		alert("slash", fmt.Sprintf("Stash slashed %s in block %s",
+			formatBalance(e.Balance.Int, m.decimals, m.unit), block.Hex()))
+	}
+
+	for _, e := range events.ImOnline_SomeOffline {
+		for _, t := range e.IdentificationTuples {
+			if t.ValidatorID != m.stash {
+				continue
+			}
+
+			alert("offline", fmt.Sprintf("Stash reported offline for the session in block %s", block.Hex()))
+		}
+	}
+
+	for _, e := range events.Staking_Chilled {
+		if e.Stash != m.stash {
+			continue
+		}
+
+		alert("chilled", fmt.Sprintf("Stash chilled in block %s", block.Hex()))
+	}
+}
+
+// checkUnappliedSlashes fires an incident for every pending slash of the stash until it is applied or cancelled.
+func (m *ChainMonitor) checkUnappliedSlashes(activeEra types.U32) error {
+	slashes, err := fetchUnappliedSlashes(m.conn.API())
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[types.U32]bool)
+	for era, eraSlashes := range slashes {
+		for _, s := range eraSlashes {
+			if s.Validator != m.stash {
+				continue
+			}
+
+			total := new(big.Int).Set(s.Own.Int)
+			for _, o := range s.Others {
+				total.Add(total, o.Amount.Int)
+			}
+
+			pending[era] = true
+			m.incidents.Fire(Incident{
+				ID:       chainIncidentID(fmt.Sprintf("unapplied_slash/%d", era)),
+				Source:   chainSource,
+				Severity: Alert,
+				Message: fmt.Sprintf("Pending slash of %s (own %s) is applied in era %d. %d eras left to cancel it",
+					formatBalance(total, m.decimals, m.unit), formatBalance(s.Own.Int, m.decimals, m.unit),
+					era, era-activeEra),
+				Details: s,
+			})
+		}
+	}
+
+	m.mu.Lock()
+	previous := m.slashEras
+	m.slashEras = pending
+	m.mu.Unlock()
+	for era := range previous {
+		if !pending[era] {
+			m.incidents.Resolve(chainIncidentID(fmt.Sprintf("unapplied_slash/%d", era)))
+		}
+	}
+
+	return nil
+}
+
+// fetchOffenders returns the offenders of the offence reports of the kind at the time slot.
+func fetchOffenders(api *ChainAPI, kind types.Bytes16, timeSlot types.Bytes) ([]types.AccountID, error) {
+	timeSlotBytes, err := types.EncodeToBytes(timeSlot)
+	if err != nil {
+		return nil, err
+	}
+
+	var reportIDs []types.Hash
+	_, err = fetchStorageOk(api, "Offences", "ConcurrentReportsIndex", kind[:], timeSlotBytes, &reportIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var offenders []types.AccountID
+	for _, id := range reportIDs {
+		var details offenceDetails
+		ok, err := fetchStorageOk(api, "Offences", "Reports", id[:], nil, &details)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			offenders = append(offenders, details.Offender.Who)
+		}
+	}
+
+	return offenders, nil
+}
+
+// fetchUnappliedSlashes returns the deferred slashes by the era they are applied in.
+func fetchUnappliedSlashes(api *ChainAPI) (map[types.U32][]UnappliedSlash, error) {
+	prefix := append(xxhash.New128([]byte("Staking")).Sum(nil),
+		xxhash.New128([]byte("UnappliedSlashes")).Sum(nil)...)
+	keys, err := api.RPC.State.GetKeysLatest(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[types.U32][]UnappliedSlash)
+	for _, key := range keys {
+		// twox_64_concat hashed era is the last 12 bytes
+		if len(key) < len(prefix)+12 {
+			continue
+		}
+
+		var era types.U32
+		err = types.DecodeFromBytes(key[len(prefix)+8:], &era)
+		if err != nil {
+			return nil, err
+		}
+
+		var slashes []UnappliedSlash
+		_, err = api.RPC.State.GetStorageLatest(key, &slashes)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(slashes) > 0 {
+			res[era] = slashes
+		}
+	}
+
+	return res, nil
+}
@@ -3,77 +3,230 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
+const (
+	defaultPagerdutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	pagerdutyMaxAttempts      = 5
+)
+
 type Pagerduty struct {
-	Name   string
-	APIKey string
+	client *http.Client
+	events chan pgEventRequestParams
+
+	mu        sync.Mutex
+	Name      string
+	APIKey    string
+	EventsURL string
+	// Severity is the minimum severity sent to pagerduty
+	Severity Severity
+	// triggered are the resolve events of the incidents triggered and not yet resolved by incident ID.
+	// They keep the routing and dedup keys the incident was triggered with across config reloads.
+	triggered map[string]pgEventRequestParams
 }
 
-func NewPagerduty(name, apiKey string) *Pagerduty {
-	return &Pagerduty{
-		Name:   name,
-		APIKey: apiKey,
+func NewPagerduty(config Config) *Pagerduty {
+	p := &Pagerduty{
+		client:    &http.Client{Timeout: 30 * time.Second},
+		events:    make(chan pgEventRequestParams, 100),
+		triggered: make(map[string]pgEventRequestParams),
 	}
+	p.Configure(config)
+	return p
+}
+
+// Configure applies the reloaded config. Triggered incidents are kept so they are still resolved.
+func (p *Pagerduty) Configure(config Config) {
+	url := config.PagerdutyEventsURL
+	if url == "" {
+		url = defaultPagerdutyEventsURL
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Name = config.Name
+	p.APIKey = config.PagerdutyAPIKey
+	p.EventsURL = url
+	p.Severity = Severity(config.PagerdutySeverity)
 }
 
 type payload struct {
-	Summary   string `json:"summary"`
-	Timestamp string `json:"timestamp"`
-	Source    string `json:"source"`
-	Severity  string `json:"severity"`
+	Summary       string      `json:"summary"`
+	Timestamp     string      `json:"timestamp"`
+	Source        string      `json:"source"`
+	Severity      string      `json:"severity"`
+	CustomDetails interface{} `json:"custom_details,omitempty"`
 }
 
 type pgEventRequestParams struct {
-	RoutingKey  string  `json:"routing_key"`
-	EventAction string  `json:"event_action"`
-	Payload     payload `json:"payload"`
+	RoutingKey  string   `json:"routing_key"`
+	EventAction string   `json:"event_action"`
+	DedupKey    string   `json:"dedup_key,omitempty"`
+	Payload     *payload `json:"payload,omitempty"`
 }
 
-func (p *Pagerduty) Start(context.Context) {
-	return
+// pagerdutySeverity maps the severity to one of pagerduty severities.
+func pagerdutySeverity(severity Severity) string {
+	switch severity {
+	case Info:
+		return "info"
+	case Warn:
+		return "warning"
+	default:
+		return "critical"
+	}
+}
+
+// Start sends the queued events to pagerduty until the ctx is cancelled.
+func (p *Pagerduty) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-p.events:
+			err := p.send(ctx, event)
+			if err != nil {
+				log.Printf("failed to send %s event %s to pagerduty: %v\n", event.EventAction, event.DedupKey, err)
+			}
+		}
+	}
 }
 
 func (p *Pagerduty) Notify(severity Severity, message string) {
-	if severity != Alert {
+	p.mu.Lock()
+	if severity < p.Severity {
+		p.mu.Unlock()
+		return
+	}
+
+	// same message is deduplicated by pagerduty while the incident is open
+	sum := sha256.Sum256([]byte(message))
+	event := p.trigger(hex.EncodeToString(sum[:]), severity, message, nil)
+	p.mu.Unlock()
+
+	p.enqueue(event)
+}
+
+// NotifyIncident triggers the incident if its severity is high enough. Resolve is sent for every
+// triggered incident regardless of the severity it was last fired with.
+func (p *Pagerduty) NotifyIncident(incident Incident) {
+	p.mu.Lock()
+	if incident.State == IncidentResolved {
+		resolve, ok := p.triggered[incident.ID]
+		delete(p.triggered, incident.ID)
+		p.mu.Unlock()
+
+		if ok {
+			p.enqueue(resolve)
+		}
+		return
+	}
+
+	if incident.Severity < p.Severity {
+		p.mu.Unlock()
 		return
 	}
 
-	params := pgEventRequestParams{
+	dedupKey := fmt.Sprintf("%s/%s", p.Name, incident.ID)
+	event := p.trigger(dedupKey, incident.Severity, incident.String(), incident.Details)
+	if resolve, ok := p.triggered[incident.ID]; ok && resolve.DedupKey != dedupKey {
+		// the name changed on reload. Incident triggered with the previous key is resolved
+		p.enqueue(resolve)
+	}
+	p.triggered[incident.ID] = pgEventRequestParams{
+		RoutingKey:  p.APIKey,
+		EventAction: "resolve",
+		DedupKey:    dedupKey,
+	}
+	p.mu.Unlock()
+
+	p.enqueue(event)
+}
+
+// trigger builds the trigger event. It's called with the lock held.
+func (p *Pagerduty) trigger(dedupKey string, severity Severity, message string, details interface{}) pgEventRequestParams {
+	return pgEventRequestParams{
 		RoutingKey:  p.APIKey,
 		EventAction: "trigger",
-		Payload: payload{
-			Summary:   message,
-			Timestamp: time.Now().Format(time.RFC3339),
-			Source:    p.Name,
-			Severity:  "critical",
+		DedupKey:    dedupKey,
+		Payload: &payload{
+			Summary:       message,
+			Timestamp:     time.Now().Format(time.RFC3339),
+			Source:        p.Name,
+			Severity:      pagerdutySeverity(severity),
+			CustomDetails: details,
 		},
 	}
+}
 
-	d, err := json.Marshal(params)
+func (p *Pagerduty) enqueue(event pgEventRequestParams) {
+	select {
+	case p.events <- event:
+	default:
+		log.Printf("pagerduty queue is full. Dropping %s event %s\n", event.EventAction, event.DedupKey)
+	}
+}
+
+// send posts the event and retries with backoff on network errors, throttling and server errors.
+func (p *Pagerduty) send(ctx context.Context, event pgEventRequestParams) error {
+	d, err := json.Marshal(event)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 
-	url := "https://events.pagerduty.com/v2/enqueue"
-	resp, err := http.Post(url, "application/json", bytes.NewReader(d))
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := p.post(d)
+		if err == nil {
+			return nil
+		}
+
+		if !retry || attempt == pagerdutyMaxAttempts {
+			return err
+		}
+
+		log.Printf("pagerduty request failed: %v. Retrying in %s...\n", err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends the event once and returns if the request can be retried on error.
+func (p *Pagerduty) post(d []byte) (retry bool, err error) {
+	p.mu.Lock()
+	url := p.EventsURL
+	p.mu.Unlock()
+
+	resp, err := p.client.Post(url, "application/json", bytes.NewReader(d))
 	if err != nil {
-		log.Printf("failed to send alert to pagerduty: %v\n", err)
-		return
+		return true, err
 	}
 
 	defer resp.Body.Close()
-	d, err = ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("failed to read pagerduty response: %v\n", err)
-		return
+		return true, fmt.Errorf("failed to read pagerduty response: %w", err)
 	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("pagerduty responded with %s: %s", resp.Status, string(body))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
 }
 
 func (p *Pagerduty) SendMessage(string) {
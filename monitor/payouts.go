@@ -6,95 +6,207 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/big"
+	"math/bits"
+	"strings"
+	"sync"
+	"time"
 
-	gsrpc "github.com/centrifuge/go-substrate-rpc-client"
-	"github.com/centrifuge/go-substrate-rpc-client/rpc/state"
 	"github.com/centrifuge/go-substrate-rpc-client/signature"
 	"github.com/centrifuge/go-substrate-rpc-client/types"
 	"github.com/decred/base58"
+	"golang.org/x/crypto/blake2b"
 )
 
 type Accountant struct {
-	api       *gsrpc.SubstrateAPI
+	conn      *Connection
 	stash     types.AccountID
 	wallet    signature.KeyringPair
 	unit      string
 	decimals  int
 	listeners []Listener
+	// dryRun only reports the payouts instead of submitting them
+	dryRun bool
+	// maxFee is the fee above which a payout batch is skipped. Nil disables the limit.
+	maxFee *big.Int
+	// mortalPeriod is the number of blocks the payout extrinsics are valid for. Zero makes them immortal.
+	mortalPeriod uint64
+	// queue submits the extrinsics of the hot wallet one at a time
+	queue *ExtrinsicQueue
+	// running is held by the payout run so the runs never plan the same unclaimed eras
+	running sync.Mutex
 }
 
-func NewAccountant(stash, hotWallet, unit string, decimals int, listeners []Listener) (*Accountant, error) {
-	api, err := gsrpc.NewSubstrateAPI("ws://127.0.0.1:9944")
+func NewAccountant(conn *Connection, config Config, listeners []Listener) (*Accountant, error) {
+	kr, err := signature.KeyringPairFromSecret(config.Payout.HotWalletURI, "")
 	if err != nil {
 		return nil, err
 	}
 
-	kr, err := signature.KeyringPairFromSecret(hotWallet, "")
+	var maxFee *big.Int
+	if config.Payout.MaxFee > 0 {
+		base := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(config.Payout.Decimals)), nil)
+		maxFee, _ = new(big.Float).Mul(big.NewFloat(config.Payout.MaxFee), new(big.Float).SetInt(base)).Int(nil)
+	}
+
+	accountID, err := getAccountID(config.Payout.Stash)
 	if err != nil {
 		return nil, err
 	}
 
-	accountID := getAccountID(stash)
 	return &Accountant{
-		api:       api,
-		stash:     accountID,
-		wallet:    kr,
-		unit:      unit,
-		decimals:  decimals,
-		listeners: listeners,
+		conn:         conn,
+		stash:        accountID,
+		wallet:       kr,
+		unit:         config.Payout.Unit,
+		decimals:     config.Payout.Decimals,
+		listeners:    listeners,
+		dryRun:       config.Payout.DryRun,
+		maxFee:       maxFee,
+		mortalPeriod: config.Payout.MortalPeriod,
+		queue:        NewExtrinsicQueue(conn, kr),
 	}, nil
 }
 
-func (a *Accountant) Start(ctx context.Context) error {
-	go func() {
-		for ctx.Err() == nil {
-			listenForEraPayout(ctx, a.api, func(block types.Hash, eraIndex types.U32) {
-				log.Println("Era finished", eraIndex)
-				a.initiatePayouts()
-			})
-		}
-	}()
-
-	go func() {
-		for ctx.Err() == nil {
-			listenForPayoutReward(ctx, a.api, a.stash, func(block types.Hash, stash types.AccountID,
-				amount types.U128) {
-				payout := amount.Div(amount.Int, big.NewInt(1).Exp(big.NewInt(10), big.NewInt(int64(a.decimals)), nil))
-				msg := fmt.Sprintf("Reward received: %s %s", payout.String(), a.unit)
-				sendMessage(msg, a.listeners)
-			})
-		}
-	}()
+// Start registers the payout handlers on the event bus and runs the submission queue.
+// Dry run reports the payout plan right away instead of waiting for the era to end.
+func (a *Accountant) Start(ctx context.Context, bus *EventBus) error {
+	go a.queue.Start(ctx)
+	if a.dryRun {
+		go a.initiatePayouts(ctx, true)
+	}
+
+	bus.OnEraPayout(func(block types.Hash, eraIndex types.U32) {
+		log.Println("Era finished", eraIndex)
+		// payouts wait for the finalization. Keep receiving the events meanwhile.
+		go a.initiatePayouts(ctx, a.dryRun)
+	})
+
+	bus.OnReward(a.stash, func(block types.Hash, stash types.AccountID, amount types.U128) {
+		// amount is shared with the other handlers of the event
+		payout := new(big.Int).Div(amount.Int, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(a.decimals)), nil))
+		msg := fmt.Sprintf("Reward received: %s %s", payout.String(), a.unit)
+		sendMessage(msg, a.listeners)
+	})
 
 	return nil
 }
 
-func (a *Accountant) initiatePayouts() {
+// PayoutBatch is a batch of the unclaimed eras paid out with a single extrinsic.
+type PayoutBatch struct {
+	Eras   []types.U32
+	Weight uint64
+	Fee    *big.Int
+}
+
+// initiatePayouts plans and pays out the unclaimed eras. Runs wait for the previous one to finish
+// so the eras it paid out are no longer unclaimed.
+func (a *Accountant) initiatePayouts(ctx context.Context, dryRun bool) {
+	a.running.Lock()
+	defer a.running.Unlock()
 	log.Println("Initiating payouts...")
-	unclaimed, err := fetchUnclaimedEra(a.api, a.stash)
+	unclaimed, err := fetchUnclaimedEra(a.conn.API(), a.stash)
 	if err != nil {
 		log.Println(fmt.Sprintf("Failed to fetch unclaimed eras: %v", err))
 		return
 	}
 	batches := batchUnclaimed(9, unclaimed)
-	nonce, err := fetchNonce(a.api, a.wallet.PublicKey)
+	// nonce doesn't affect the fee. Submission queue signs with the actual nonce.
+	nonce, err := fetchNonce(a.conn.API(), a.wallet.Address)
 	if err != nil {
 		log.Println("failed to fetch nonce", err)
+		sendMessage(fmt.Sprintf("Failed to plan payouts: %v", err), a.listeners)
+		return
 	}
+
+	var plan []PayoutBatch
 	for _, batch := range batches {
-		err := payout(a.api, a.stash, batch, a.wallet, nonce)
+		pb, err := a.planBatch(batch, nonce)
+		if err != nil {
+			log.Println(err)
+			sendMessage(fmt.Sprintf("Failed to plan payout of eras %v: %v", batch, err), a.listeners)
+			return
+		}
+
+		if a.maxFee != nil && pb.Fee.Cmp(a.maxFee) > 0 && !dryRun {
+			sendMessage(fmt.Sprintf("Skipping payout of eras %v. Fee %s is above the max fee %s", batch,
+				formatBalance(pb.Fee, a.decimals, a.unit), formatBalance(a.maxFee, a.decimals, a.unit)), a.listeners)
+			continue
+		}
+
+		plan = append(plan, pb)
+	}
+
+	if dryRun {
+		sendMessage(a.planSummary(plan), a.listeners)
+		return
+	}
+
+	for _, pb := range plan {
+		eras := pb.Eras
+		block, ext, err := a.queue.Submit(ctx, func(nonce types.U32) (types.Extrinsic, uint64, error) {
+			return buildPayout(a.conn.API(), a.stash, eras, a.wallet, nonce, a.mortalPeriod)
+		})
+		if err != nil {
+			log.Println(err)
+			sendMessage(fmt.Sprintf("Payout of eras %v failed: %v", pb.Eras, err), a.listeners)
+			continue
+		}
+
+		result, err := fetchPayoutResult(a.conn.API(), block, ext, pb.Eras)
 		if err != nil {
 			log.Println(err)
+			sendMessage(fmt.Sprintf("Payout of eras %v finalized in block %s with unknown outcome: %v",
+				pb.Eras, block.Hex(), err), a.listeners)
+			continue
 		}
 
-		nonce = nonce + 1
+		sendMessage(result.String(), a.listeners)
 	}
 	log.Println("Payouts claimed...")
 }
 
-func (a *Accountant) Payout() {
-	a.initiatePayouts()
+// planBatch builds and signs the payout extrinsic of the eras and estimates its fee.
+func (a *Accountant) planBatch(eras []types.U32, nonce types.U32) (PayoutBatch, error) {
+	ext, _, err := buildPayout(a.conn.API(), a.stash, eras, a.wallet, nonce, a.mortalPeriod)
+	if err != nil {
+		return PayoutBatch{}, err
+	}
+
+	info, err := queryFeeInfo(a.conn.API(), ext)
+	if err != nil {
+		return PayoutBatch{}, err
+	}
+
+	return PayoutBatch{Eras: eras, Weight: info.Weight, Fee: info.PartialFee.Int}, nil
+}
+
+func (a *Accountant) planSummary(plan []PayoutBatch) string {
+	if len(plan) == 0 {
+		return "Payout dry run: no unclaimed eras"
+	}
+
+	var buf strings.Builder
+	buf.WriteString("Payout dry run\n")
+	total := big.NewInt(0)
+	for i, pb := range plan {
+		buf.WriteString(fmt.Sprintf("Batch %d: eras %v, weight %d, fee %s", i+1, pb.Eras, pb.Weight,
+			formatBalance(pb.Fee, a.decimals, a.unit)))
+		if a.maxFee != nil && pb.Fee.Cmp(a.maxFee) > 0 {
+			buf.WriteString(" (above the max fee, skipped)")
+		}
+		buf.WriteString("\n")
+		total.Add(total, pb.Fee)
+	}
+	buf.WriteString(fmt.Sprintf("Total fee: %s", formatBalance(total, a.decimals, a.unit)))
+	return buf.String()
+}
+
+// Payout pays out the unclaimed eras. Dry run only reports the planned payouts and their fees.
+// Payouts are always a dry run if the accountant is configured so.
+func (a *Accountant) Payout(ctx context.Context, dryRun bool) {
+	a.initiatePayouts(ctx, dryRun || a.dryRun)
 }
 
 func sendMessage(msg string, listeners []Listener) {
@@ -103,37 +215,60 @@ func sendMessage(msg string, listeners []Listener) {
 	}
 }
 
-func fetchNonce(api *gsrpc.SubstrateAPI, pub []byte) (types.U32, error) {
-	meta, err := api.RPC.State.GetMetadataLatest()
-	if err != nil {
-		return 0, err
-	}
-	key, err := types.CreateStorageKey(meta, "System", "Account", pub, nil)
-	if err != nil {
-		return 0, err
+// fetchNonce returns the next nonce of the account including its transactions in the pool.
+func fetchNonce(api *ChainAPI, address string) (types.U32, error) {
+	var nonce uint32
+	err := api.Client.Call(&nonce, "system_accountNextIndex", address)
+	return types.U32(nonce), err
+}
+
+// feeInfo is the dispatch info of an extrinsic returned by payment_queryInfo.
+type feeInfo struct {
+	Weight     uint64     `json:"weight"`
+	Class      string     `json:"class"`
+	PartialFee rpcBalance `json:"partialFee"`
+}
+
+// rpcBalance is a balance the RPC encodes either as a number or a decimal or hex string.
+type rpcBalance struct {
+	*big.Int
+}
+
+func (b *rpcBalance) UnmarshalJSON(data []byte) error {
+	v, ok := new(big.Int).SetString(strings.Trim(string(data), `"`), 0)
+	if !ok {
+		return fmt.Errorf("invalid balance: %s", data)
 	}
 
-	var accountInfo types.AccountInfo
-	ok, err := api.RPC.State.GetStorageLatest(key, &accountInfo)
-	if err != nil || !ok {
-		return 0, err
+	b.Int = v
+	return nil
+}
+
+// queryFeeInfo returns the weight and the fee of the signed extrinsic.
+func queryFeeInfo(api *ChainAPI, ext types.Extrinsic) (feeInfo, error) {
+	var info feeInfo
+	enc, err := types.EncodeToHexString(ext)
+	if err != nil {
+		return info, err
 	}
 
-	return accountInfo.Nonce, err
+	return info, api.Client.Call(&info, "payment_queryInfo", enc)
 }
 
-func payout(api *gsrpc.SubstrateAPI, stash types.AccountID, eras []types.U32, kr signature.KeyringPair,
-	nonce types.U32) error {
-	meta, err := api.RPC.State.GetMetadataLatest()
+// buildPayout returns the signed batch extrinsic paying out the eras of the stash and the block it expires after.
+// The extrinsic is mortal for the period checkpointed at the finalized head. Zero period makes it immortal.
+func buildPayout(api *ChainAPI, stash types.AccountID, eras []types.U32, kr signature.KeyringPair,
+	nonce types.U32, period uint64) (ext types.Extrinsic, expiresAt uint64, err error) {
+	meta, err := api.LatestMetadata()
 	if err != nil {
-		return err
+		return ext, 0, err
 	}
 
 	var calls []types.Call
 	for _, era := range eras {
 		c, err := types.NewCall(meta, "Staking.payout_stakers", stash, era)
 		if err != nil {
-			return err
+			return ext, 0, err
 		}
 
 		calls = append(calls, c)
@@ -141,24 +276,32 @@ func payout(api *gsrpc.SubstrateAPI, stash types.AccountID, eras []types.U32, kr
 
 	c, err := types.NewCall(meta, "Utility.batch", calls)
 	if err != nil {
-		return err
+		return ext, 0, err
 	}
 
 	// Create the extrinsic
-	ext := types.NewExtrinsic(c)
+	ext = types.NewExtrinsic(c)
 	genesisHash, err := api.RPC.Chain.GetBlockHash(0)
 	if err != nil {
-		return err
+		return ext, 0, err
 	}
 
 	rv, err := api.RPC.State.GetRuntimeVersionLatest()
 	if err != nil {
-		return err
+		return ext, 0, err
+	}
+
+	era, blockHash, expiresAt := types.ExtrinsicEra{IsMortalEra: false}, genesisHash, uint64(math.MaxUint64)
+	if period > 0 {
+		era, blockHash, expiresAt, err = mortalCheckpoint(api, period)
+		if err != nil {
+			return ext, 0, err
+		}
 	}
 
 	o := types.SignatureOptions{
-		BlockHash:          genesisHash,
-		Era:                types.ExtrinsicEra{IsMortalEra: false},
+		BlockHash:          blockHash,
+		Era:                era,
 		GenesisHash:        genesisHash,
 		Nonce:              types.NewUCompactFromUInt(uint64(nonce)),
 		SpecVersion:        rv.SpecVersion,
@@ -168,147 +311,176 @@ func payout(api *gsrpc.SubstrateAPI, stash types.AccountID, eras []types.U32, kr
 
 	// Sign the transaction using Alice's default account
 	err = ext.Sign(kr, o)
+	return ext, expiresAt, err
+}
+
+// mortalCheckpoint returns the mortal era of the period starting at the finalized head, the hash of
+// the era birth block and the block the era ends at.
+func mortalCheckpoint(api *ChainAPI, period uint64) (era types.ExtrinsicEra, birthHash types.Hash,
+	expiresAt uint64, err error) {
+	finalized, err := api.RPC.Chain.GetFinalizedHead()
 	if err != nil {
-		return err
+		return era, birthHash, 0, err
 	}
 
-	// Send the extrinsic
-	sub, err := api.RPC.Author.SubmitAndWatchExtrinsic(ext)
+	header, err := api.RPC.Chain.GetHeader(finalized)
 	if err != nil {
-		return err
+		return era, birthHash, 0, err
 	}
 
-	defer sub.Unsubscribe()
-	select {
-	case c := <-sub.Chan():
-		if c.IsInvalid {
-			return errors.New("invalid extrinsic")
+	current := uint64(header.Number)
+	era, birth, period := mortalEra(current, period)
+	birthHash = finalized
+	if birth != current {
+		birthHash, err = api.RPC.Chain.GetBlockHash(birth)
+		if err != nil {
+			return era, birthHash, 0, err
 		}
-		return nil
-	case err := <-sub.Err():
-		return err
 	}
-}
 
-func getAccountID(address string) types.AccountID {
-	data := base58.Decode(address)
-	return types.NewAccountID(data[1 : len(data)-2])
+	return era, birthHash, birth + period, nil
 }
 
-func getEventSubscription(api *gsrpc.SubstrateAPI) (
-	sub *state.StorageSubscription,
-	meta *types.Metadata,
-	key types.StorageKey, err error) {
-	meta, err = api.RPC.State.GetMetadataLatest()
-	if err != nil {
-		return nil, nil, nil, err
+// mortalEra encodes the mortal era of the period for the current block as substrate does.
+// Period is rounded up to a power of two within [4, 65536]. Returns the era birth block and the final period.
+func mortalEra(current, period uint64) (era types.ExtrinsicEra, birth, finalPeriod uint64) {
+	p := uint64(4)
+	for p < period && p < 1<<16 {
+		p <<= 1
 	}
 
-	// Subscribe to system events via storage
-	key, err = types.CreateStorageKey(meta, "System", "Events", nil, nil)
-	if err != nil {
-		return nil, nil, nil, err
+	phase := current % p
+	quantizeFactor := p >> 12
+	if quantizeFactor < 1 {
+		quantizeFactor = 1
+	}
+
+	quantizedPhase := phase / quantizeFactor * quantizeFactor
+	tz := bits.TrailingZeros64(p) - 1
+	if tz < 1 {
+		tz = 1
+	}
+	if tz > 15 {
+		tz = 15
 	}
 
-	sub, err = api.RPC.State.SubscribeStorageRaw([]types.StorageKey{key})
-	return sub, meta, key, err
+	encoded := uint16(tz) | uint16(quantizedPhase/quantizeFactor)<<4
+	era = types.ExtrinsicEra{
+		IsMortalEra: true,
+		AsMortalEra: types.MortalEra{First: byte(encoded), Second: byte(encoded >> 8)},
+	}
+	return era, current - (phase - quantizedPhase), p
 }
 
-func listenForEraPayout(ctx context.Context, api *gsrpc.SubstrateAPI, onEraFinish func(block types.Hash,
-	eraIndex types.U32)) (err error) {
-	defer fmt.Println("Finished watching ERA payout events", err)
-	log.Println("Watching for ERA Payout events...")
+var errExtrinsicExpired = errors.New("extrinsic expired before inclusion")
+
+// expiryCheckInterval is how often the chain head is checked against the extrinsic expiry.
+const expiryCheckInterval = 30 * time.Second
 
-	sub, meta, key, err := getEventSubscription(api)
+// submitAndWatch submits the extrinsic and waits till the block including it is finalized or the ctx
+// is cancelled. errExtrinsicExpired is returned if the chain passes expiresAt before the inclusion.
+func submitAndWatch(ctx context.Context, api *ChainAPI, ext types.Extrinsic, expiresAt uint64) (types.Hash,
+	error) {
+	// Send the extrinsic
+	sub, err := api.RPC.Author.SubmitAndWatchExtrinsic(ext)
 	if err != nil {
-		return err
+		return types.Hash{}, err
 	}
 
 	defer sub.Unsubscribe()
-
+	tick := time.NewTicker(expiryCheckInterval)
+	defer tick.Stop()
+	var included bool
 	for {
 		select {
 		case <-ctx.Done():
-			err = ctx.Err()
-			return err
-		case err = <-sub.Err():
-			return err
-		case set := <-sub.Chan():
-			// inner loop for the changes within one of those notifications
-			for _, chng := range set.Changes {
-				if !types.Eq(chng.StorageKey, key) || !chng.HasStorageData {
-					// skip, we are only interested in events with content
-					continue
-				}
-
-				// Decode the event records
-				events := types.EventRecords{}
-				err = types.EventRecordsRaw(chng.StorageData).DecodeEventRecords(meta, &events)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-
-				for _, e := range events.Staking_EraPayout {
-					onEraFinish(set.Block, e.EraIndex)
+			return types.Hash{}, ctx.Err()
+		case status := <-sub.Chan():
+			switch {
+			case status.IsInBlock:
+				log.Printf("Extrinsic included in block %s\n", status.AsInBlock.Hex())
+				included = true
+			case status.IsRetracted:
+				// block is no longer canonical. wait for the inclusion in another.
+				included = false
+			case status.IsFinalized:
+				return status.AsFinalized, nil
+			case status.IsFinalityTimeout:
+				return types.Hash{}, fmt.Errorf("block %s is not finalized in time", status.AsFinalityTimeout.Hex())
+			case status.IsInvalid, status.IsDropped:
+				// pool drops the expired extrinsics as invalid
+				if expired(api, expiresAt) {
+					return types.Hash{}, errExtrinsicExpired
 				}
+				return types.Hash{}, errors.New("invalid extrinsic")
+			case status.IsUsurped:
+				return types.Hash{}, errors.New("extrinsic usurped by another with the same nonce")
+			}
+		case err := <-sub.Err():
+			return types.Hash{}, err
+		case <-tick.C:
+			if !included && expired(api, expiresAt) {
+				return types.Hash{}, errExtrinsicExpired
 			}
 		}
 	}
 }
 
-func listenForPayoutReward(
-	ctx context.Context,
-	api *gsrpc.SubstrateAPI,
-	stash types.AccountID,
-	onReward func(block types.Hash, stash types.AccountID, amount types.U128)) (err error) {
-	defer fmt.Println("Finished watching reward events", err)
-	log.Println("Watching for reward events...")
-
-	sub, meta, key, err := getEventSubscription(api)
+// expired reports if the best block is past expiresAt.
+func expired(api *ChainAPI, expiresAt uint64) bool {
+	header, err := api.RPC.Chain.GetHeaderLatest()
 	if err != nil {
-		log.Println(err)
-		return err
+		return false
 	}
 
-	defer sub.Unsubscribe()
+	return uint64(header.Number) > expiresAt
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			err = ctx.Err()
-			return err
-		case err = <-sub.Err():
-			return err
-		case set := <-sub.Chan():
-			// inner loop for the changes within one of those notifications
-			for _, chng := range set.Changes {
-				if !types.Eq(chng.StorageKey, key) || !chng.HasStorageData {
-					// skip, we are only interested in events with content
-					continue
-				}
+// ss58Length is the length of a decoded SS58 address with a one byte prefix and a two byte checksum.
+const ss58Length = 1 + 32 + 2
 
-				// Decode the event records
-				events := types.EventRecords{}
-				err = types.EventRecordsRaw(chng.StorageData).DecodeEventRecords(meta, &events)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
+// decodeAddress returns the decoded SS58 address after checking its length and checksum.
+func decodeAddress(address string) ([]byte, error) {
+	data := base58.Decode(address)
+	if len(data) != ss58Length {
+		return nil, fmt.Errorf("invalid address %q", address)
+	}
 
-				for _, e := range events.Staking_Reward {
-					if !bytes.Equal(e.Stash[:], stash[:]) {
-						continue
-					}
+	checksum := blake2b.Sum512(append([]byte("SS58PRE"), data[:ss58Length-2]...))
+	if !bytes.Equal(checksum[:2], data[ss58Length-2:]) {
+		return nil, fmt.Errorf("invalid address checksum %q", address)
+	}
 
-					onReward(set.Block, e.Stash, e.Amount)
-				}
-			}
-		}
+	return data, nil
+}
+
+func getAccountID(address string) (types.AccountID, error) {
+	data, err := decodeAddress(address)
+	if err != nil {
+		return types.AccountID{}, err
+	}
+
+	return types.NewAccountID(data[1 : len(data)-2]), nil
+}
+
+// getNetworkPrefix returns the SS58 network prefix of the address.
+func getNetworkPrefix(address string) (byte, error) {
+	data, err := decodeAddress(address)
+	if err != nil {
+		return 0, err
 	}
+
+	return data[0], nil
 }
 
-func fetchUnclaimedEra(api *gsrpc.SubstrateAPI, stash types.AccountID) ([]types.U32, error) {
+// getAddress returns the SS58 address of the account for the network prefix.
+func getAddress(id types.AccountID, prefix byte) string {
+	data := append([]byte{prefix}, id[:]...)
+	checksum := blake2b.Sum512(append([]byte("SS58PRE"), data...))
+	return base58.Encode(append(data, checksum[:2]...))
+}
+
+func fetchUnclaimedEra(api *ChainAPI, stash types.AccountID) ([]types.U32, error) {
 	controller, err := bonded(api, stash)
 	if err != nil {
 		return nil, err
@@ -389,7 +561,7 @@ type Exposure struct {
 	}
 }
 
-func historyDepth(api *gsrpc.SubstrateAPI, or types.U32) types.U32 {
+func historyDepth(api *ChainAPI, or types.U32) types.U32 {
 	var depth types.U32
 	err := fetchStorage(api, "Staking", "HistoryDepth", nil, nil, &depth)
 	if err != nil {
@@ -399,17 +571,17 @@ func historyDepth(api *gsrpc.SubstrateAPI, or types.U32) types.U32 {
 	return depth
 }
 
-func bonded(api *gsrpc.SubstrateAPI, stash types.AccountID) (acc types.AccountID, err error) {
+func bonded(api *ChainAPI, stash types.AccountID) (acc types.AccountID, err error) {
 	var controller types.AccountID
 	return controller, fetchStorage(api, "Staking", "Bonded", stash[:], nil, &controller)
 }
 
-func fetchClaimed(api *gsrpc.SubstrateAPI, controller types.AccountID) (unclaimed []types.U32, err error) {
+func fetchClaimed(api *ChainAPI, controller types.AccountID) (unclaimed []types.U32, err error) {
 	var res StakingLedger
 	return res.ClaimedRewards, fetchStorage(api, "Staking", "Ledger", controller[:], nil, &res)
 }
 
-func activeEra(api *gsrpc.SubstrateAPI) (types.U32, error) {
+func activeEra(api *ChainAPI) (types.U32, error) {
 	var eraInfo struct {
 		Era   types.U32
 		Start types.OptionU64
@@ -417,31 +589,46 @@ func activeEra(api *gsrpc.SubstrateAPI) (types.U32, error) {
 	return eraInfo.Era, fetchStorage(api, "Staking", "ActiveEra", nil, nil, &eraInfo)
 }
 
-func fetchExposure(api *gsrpc.SubstrateAPI, era types.U32, stash types.AccountID) (Exposure, error) {
+// currentEra returns the planned era. It is ahead of the active era once the next era is elected.
+func currentEra(api *ChainAPI) (types.U32, error) {
+	var era types.U32
+	_, err := fetchStorageOk(api, "Staking", "CurrentEra", nil, nil, &era)
+	return era, err
+}
+
+// fetchExposure returns the exposure of the stash in the era. Exposure is empty if the stash is not elected.
+func fetchExposure(api *ChainAPI, era types.U32, stash types.AccountID) (Exposure, error) {
 	var res Exposure
 	eraBytes, err := types.EncodeToBytes(era)
 	if err != nil {
 		return res, err
 	}
 
-	return res, fetchStorage(api, "Staking", "ErasStakers", eraBytes, stash[:], &res)
+	_, err = fetchStorageOk(api, "Staking", "ErasStakers", eraBytes, stash[:], &res)
+	return res, err
 }
 
-func fetchStorage(api *gsrpc.SubstrateAPI, prefix, method string, arg1, arg2 []byte, target interface{}) error {
-	meta, err := api.RPC.State.GetMetadataLatest()
-	if err != nil {
-		return err
+func fetchStorage(api *ChainAPI, prefix, method string, arg1, arg2 []byte, target interface{}) error {
+	ok, err := fetchStorageOk(api, prefix, method, arg1, arg2, target)
+	if err != nil || !ok {
+		return fmt.Errorf("failed to fetch storage: %w", err)
 	}
 
-	key, err := types.CreateStorageKey(meta, prefix, method, arg1, arg2)
+	return nil
+}
+
+// fetchStorageOk is fetchStorage that reports a missing value with ok instead of an error.
+func fetchStorageOk(api *ChainAPI, prefix, method string, arg1, arg2 []byte,
+	target interface{}) (ok bool, err error) {
+	meta, err := api.LatestMetadata()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	ok, err := api.RPC.State.GetStorageLatest(key, target)
-	if err != nil || !ok {
-		return fmt.Errorf("failed to fetch storage: %w", err)
+	key, err := types.CreateStorageKey(meta, prefix, method, arg1, arg2)
+	if err != nil {
+		return false, err
 	}
 
-	return nil
+	return api.RPC.State.GetStorageLatest(key, target)
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/types"
+)
+
+// Eras are the ones substrate and polkadot-js encode for the same period and block.
+func TestMortalEra(t *testing.T) {
+	tests := []struct {
+		current, period uint64
+		want            types.MortalEra
+		birth           uint64
+		finalPeriod     uint64
+	}{
+		{current: 42, period: 64, want: types.MortalEra{First: 165, Second: 2}, birth: 42, finalPeriod: 64},
+		{current: 64, period: 64, want: types.MortalEra{First: 5, Second: 0}, birth: 64, finalPeriod: 64},
+		{current: 100, period: 64, want: types.MortalEra{First: 69, Second: 2}, birth: 100, finalPeriod: 64},
+		{current: 20000, period: 32768, want: types.MortalEra{First: 78, Second: 156}, birth: 20000, finalPeriod: 32768},
+		// period rounded up to a power of two
+		{current: 513, period: 200, want: types.MortalEra{First: 23, Second: 0}, birth: 513, finalPeriod: 256},
+		{current: 1, period: 2, want: types.MortalEra{First: 17, Second: 0}, birth: 1, finalPeriod: 4},
+		// period clamped to 65536 and the phase quantized to 16 blocks
+		{current: 1000001, period: 1000000, want: types.MortalEra{First: 79, Second: 66}, birth: 1000000,
+			finalPeriod: 65536},
+	}
+
+	for _, tt := range tests {
+		era, birth, finalPeriod := mortalEra(tt.current, tt.period)
+		if !era.IsMortalEra || era.AsMortalEra != tt.want {
+			t.Errorf("mortalEra(%d, %d) era = %+v, want %+v", tt.current, tt.period, era, tt.want)
+		}
+
+		if birth != tt.birth || finalPeriod != tt.finalPeriod {
+			t.Errorf("mortalEra(%d, %d) birth, period = %d, %d, want %d, %d", tt.current, tt.period,
+				birth, finalPeriod, tt.birth, tt.finalPeriod)
+		}
+	}
+}
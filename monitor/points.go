@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/centrifuge/go-substrate-rpc-client/types"
+)
+
+const (
+	// pointsHistoryEras is the number of eras reported by the /points command.
+	pointsHistoryEras = 7
+	// minMedianPoints is the median below which the era is too young to compare the points.
+	minMedianPoints = 100
+)
+
+// EraRewardPoints are the reward points of the validators in an era.
+type EraRewardPoints struct {
+	Total      types.U32
+	Individual []struct {
+		Who    types.AccountID
+		Points types.U32
+	}
+}
+
+// EraPoints are the reward points of the stash in an era compared to the rest of the active set.
+type EraPoints struct {
+	Era    types.U32
+	Points types.U32
+	Median types.U32
+	// Percentile of the validators with fewer points than the stash
+	Percentile float64
+	Validators int
+}
+
+func (p EraPoints) String() string {
+	return fmt.Sprintf("Era %d: %d points, median %d, p%.0f of %d validators",
+		p.Era, p.Points, p.Median, p.Percentile, p.Validators)
+}
+
+// checkPoints warns when the stash points of the last finished era fell significantly below the median.
+// Active era is not compared as its points depend on the slot randomness until late in the era.
+func (m *ChainMonitor) checkPoints(ms Membership) error {
+	id := chainIncidentID("era_points")
+	if ms.ActiveEra == 0 || m.pointsRatio <= 0 {
+		m.incidents.Resolve(id)
+		return nil
+	}
+
+	era := ms.ActiveEra - 1
+	exposure, err := fetchExposure(m.conn.API(), era, m.stash)
+	if err != nil {
+		return err
+	}
+
+	// stash was not in the active set of the era
+	if total := big.Int(exposure.Total); total.Sign() == 0 {
+		m.incidents.Resolve(id)
+		return nil
+	}
+
+	m.mu.RLock()
+	finished, ok := m.points[era]
+	m.mu.RUnlock()
+	if !ok {
+		finished, err = fetchEraPoints(m.conn.API(), era, m.stash)
+		if err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		m.points[era] = finished
+		m.mu.Unlock()
+	}
+
+	if finished.Median < minMedianPoints || float64(finished.Points) >= m.pointsRatio*float64(finished.Median) {
+		m.incidents.Resolve(id)
+		return nil
+	}
+
+	m.incidents.Fire(Incident{
+		ID:       id,
+		Source:   chainSource,
+		Severity: Warn,
+		Message: fmt.Sprintf("Era %d points %d were below %.0f%% of the median %d. Percentile: %.0f",
+			finished.Era, finished.Points, m.pointsRatio*100, finished.Median, finished.Percentile),
+		Details: finished,
+	})
+	return nil
+}
+
+// PointsHistory returns the stash points of the recent eras.
+// Points of the finished eras are cached as they no longer change. Active era points are always fetched.
+func (m *ChainMonitor) PointsHistory() (string, error) {
+	active, err := activeEra(m.conn.API())
+	if err != nil {
+		return "", err
+	}
+
+	from := types.U32(0)
+	if active >= pointsHistoryEras {
+		from = active - pointsHistoryEras + 1
+	}
+
+	var buf strings.Builder
+	for era := from; era <= active; era++ {
+		m.mu.RLock()
+		points, ok := m.points[era]
+		m.mu.RUnlock()
+		if !ok || era == active {
+			points, err = fetchEraPoints(m.conn.API(), era, m.stash)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		if era < active {
+			m.mu.Lock()
+			m.points[era] = points
+			m.mu.Unlock()
+		}
+		buf.WriteString(points.String())
+		buf.WriteString("\n")
+	}
+
+	m.mu.Lock()
+	for era := range m.points {
+		if era < from {
+			delete(m.points, era)
+		}
+	}
+	m.mu.Unlock()
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func fetchEraPoints(api *ChainAPI, era types.U32, stash types.AccountID) (EraPoints, error) {
+	eraBytes, err := types.EncodeToBytes(era)
+	if err != nil {
+		return EraPoints{}, err
+	}
+
+	var rp EraRewardPoints
+	_, err = fetchStorageOk(api, "Staking", "ErasRewardPoints", eraBytes, nil, &rp)
+	if err != nil {
+		return EraPoints{}, err
+	}
+
+	return eraPoints(era, rp, stash), nil
+}
+
+// eraPoints compares the stash points against the validators with points in the era.
+func eraPoints(era types.U32, rp EraRewardPoints, stash types.AccountID) EraPoints {
+	res := EraPoints{Era: era, Validators: len(rp.Individual)}
+	if len(rp.Individual) == 0 {
+		return res
+	}
+
+	points := make([]types.U32, 0, len(rp.Individual))
+	for _, i := range rp.Individual {
+		if i.Who == stash {
+			res.Points = i.Points
+		}
+		points = append(points, i.Points)
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+	res.Median = points[len(points)/2]
+	below := sort.Search(len(points), func(i int) bool { return points[i] >= res.Points })
+	res.Percentile = float64(below) / float64(len(points)) * 100
+	return res
+}
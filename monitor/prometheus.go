@@ -1,16 +1,14 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"math/big"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -44,10 +42,13 @@ type Metrics struct {
 	QueuedBlocks   int            `json:"queued_blocks"`
 	IsMajorSyncing bool           `json:"is_major_syncing"`
 	ValidatorStats ValidatorStats `json:"validator_stats"`
+
+	// Families are all the scraped metrics
+	Families Families `json:"-"`
 }
 
-func fetchDataFromPrometheus() ([]byte, error) {
-	u := "http://127.0.0.1:9615/metrics"
+// fetchDataFromPrometheus returns the exposition and its content type.
+func fetchDataFromPrometheus(u string) ([]byte, string, error) {
 	var resp *http.Response
 	var err error
 	for i := 0; i < 5; i++ {
@@ -66,43 +67,11 @@ func fetchDataFromPrometheus() ([]byte, error) {
 			time.Sleep(time.Minute)
 			continue
 		}
-		return d, nil
+		return d, resp.Header.Get("Content-Type"), nil
 	}
 
 	log.Println("Giving up. Notifying Admins...")
-	return nil, errors.New("Failed to get metrics. Node maybe down!")
-}
-
-func parseData(data []byte) map[string]string {
-	m := make(map[string]string)
-	b := bufio.NewScanner(bytes.NewReader(data))
-	for b.Scan() {
-		if strings.HasPrefix(b.Text(), "#") {
-			continue
-		}
-
-		splits := strings.Split(b.Text(), " ")
-		m[splits[0]] = splits[1]
-	}
-	return m
-}
-
-func mustInt(d string) int {
-	v, err := strconv.Atoi(d)
-	if err != nil {
-		panic(err)
-	}
-
-	return v
-}
-
-func mustBool(d string) bool {
-	v, err := strconv.ParseBool(d)
-	if err != nil {
-		panic(err)
-	}
-
-	return v
+	return nil, "", errors.New("Failed to get metrics. Node maybe down!")
 }
 
 func mustBigInt(s string) *bint {
@@ -115,50 +84,78 @@ func mustBigInt(s string) *bint {
 	return &bint{*i}
 }
 
-func FetchMetrics() (Metrics, error) {
+func bigIntValue(v float64) (*bint, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("invalid big int: %v", v)
+	}
+
+	i, _ := big.NewFloat(v).Int(nil)
+	return &bint{*i}, nil
+}
+
+var (
+	directionIn       = MustLabelMatcher(MatchEqual, "direction", "in")
+	directionOut      = MustLabelMatcher(MatchEqual, "direction", "out")
+	statusBest        = MustLabelMatcher(MatchEqual, "status", "best")
+	statusFinalized   = MustLabelMatcher(MatchEqual, "status", "finalized")
+	statusSyncTarget  = MustLabelMatcher(MatchEqual, "status", "sync_target")
+	errMetricsMissing = errors.New("metrics missing")
+)
+
+func FetchMetrics(node *Node) (Metrics, error) {
 	var metrics Metrics
-	data, err := fetchDataFromPrometheus()
+	data, contentType, err := fetchDataFromPrometheus(node.PrometheusURL)
 	if err != nil {
 		return metrics, err
 	}
 
-	m := parseData(data)
-	for k, v := range m {
-		switch k {
-		case "substrate_node_roles":
-			metrics.NodeRoles = mustInt(v)
-		case "substrate_sub_libp2p_network_bytes_total{direction=\"out\"}":
-			metrics.LibP2P.Network.Out = mustBigInt(v)
-		case "substrate_sub_libp2p_peerset_num_discovered":
-			metrics.LibP2P.PeerSetDiscovered = mustInt(v)
-		case "substrate_sub_libp2p_peerset_num_requested":
-			metrics.LibP2P.PeerSetRequested = mustInt(v)
-		case "substrate_sync_fork_targets":
-			metrics.ForkTargets = mustInt(v)
-		case "substrate_sync_peers":
-			metrics.SyncPeers = mustInt(v)
-		case "substrate_sync_queued_blocks":
-			metrics.QueuedBlocks = mustInt(v)
-		case "substrate_block_height{status=\"best\"}":
-			metrics.BlockHeight.Best = mustBigInt(v)
-		case "substrate_block_height{status=\"sync_target\"}":
-			metrics.BlockHeight.SyncTarget = mustBigInt(v)
-		case "substrate_sub_libp2p_is_major_syncing":
-			metrics.IsMajorSyncing = mustBool(v)
-		case "substrate_sub_libp2p_network_bytes_total{direction=\"in\"}":
-			metrics.LibP2P.Network.In = mustBigInt(v)
-		case "substrate_block_height{status=\"finalized\"}":
-			metrics.BlockHeight.Finalized = mustBigInt(v)
-		case "substrate_sub_libp2p_peers_count":
-			metrics.Peers = mustInt(v)
+	families, err := ParseExposition(data, contentType)
+	if err != nil {
+		return metrics, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+
+	if len(families) < 1 {
+		return metrics, errMetricsMissing
+	}
+	metrics.Families = families
+
+	intValue := func(name string, matchers ...*LabelMatcher) int {
+		v, _ := families.Value(name, matchers...)
+		return int(v)
+	}
+
+	var bigErr error
+	bigValue := func(name string, matchers ...*LabelMatcher) *bint {
+		v, ok := families.Value(name, matchers...)
+		if !ok {
+			return nil
 		}
+
+		b, err := bigIntValue(v)
+		if err != nil && bigErr == nil {
+			bigErr = fmt.Errorf("%s: %w", name, err)
+		}
+		return b
 	}
 
-	vs, err := fetchValidatorStats()
-	if err != nil {
-		return metrics, err
+	metrics.NodeRoles = intValue("substrate_node_roles")
+	metrics.LibP2P.PeerSetDiscovered = intValue("substrate_sub_libp2p_peerset_num_discovered")
+	metrics.LibP2P.PeerSetRequested = intValue("substrate_sub_libp2p_peerset_num_requested")
+	metrics.LibP2P.Network.In = bigValue("substrate_sub_libp2p_network_bytes_total", directionIn)
+	metrics.LibP2P.Network.Out = bigValue("substrate_sub_libp2p_network_bytes_total", directionOut)
+	metrics.ForkTargets = intValue("substrate_sync_fork_targets")
+	metrics.SyncPeers = intValue("substrate_sync_peers")
+	metrics.QueuedBlocks = intValue("substrate_sync_queued_blocks")
+	metrics.BlockHeight.Best = bigValue("substrate_block_height", statusBest)
+	metrics.BlockHeight.Finalized = bigValue("substrate_block_height", statusFinalized)
+	metrics.BlockHeight.SyncTarget = bigValue("substrate_block_height", statusSyncTarget)
+	metrics.IsMajorSyncing = intValue("substrate_sub_libp2p_is_major_syncing") != 0
+	metrics.Peers = intValue("substrate_sub_libp2p_peers_count")
+	if bigErr != nil {
+		return metrics, bigErr
 	}
-	metrics.ValidatorStats = vs
+
+	metrics.ValidatorStats = node.Stats.Stats()
 	return metrics, nil
 }
 
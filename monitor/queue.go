@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/centrifuge/go-substrate-rpc-client/signature"
+	"github.com/centrifuge/go-substrate-rpc-client/types"
+)
+
+// maxSubmitAttempts is the number of times an extrinsic is rebuilt and submitted after
+// it expires or its nonce conflicts with another transaction of the signer.
+const maxSubmitAttempts = 3
+
+// nonceConflicts are the pool errors of the transactions submitted with an already used nonce.
+var nonceConflicts = []string{"Priority is too low", "Transaction is outdated"}
+
+func isNonceConflict(err error) bool {
+	for _, c := range nonceConflicts {
+		if strings.Contains(err.Error(), c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BuildExtrinsic returns the extrinsic signed with the nonce and the block it expires after.
+type BuildExtrinsic func(nonce types.U32) (ext types.Extrinsic, expiresAt uint64, err error)
+
+type submission struct {
+	build  BuildExtrinsic
+	result chan submissionResult
+}
+
+type submissionResult struct {
+	block types.Hash
+	ext   types.Extrinsic
+	err   error
+}
+
+// ExtrinsicQueue submits the extrinsics of a signer one at a time so that each is signed with
+// the next nonce of the signer.
+type ExtrinsicQueue struct {
+	conn        *Connection
+	signer      signature.KeyringPair
+	submissions chan submission
+}
+
+func NewExtrinsicQueue(conn *Connection, signer signature.KeyringPair) *ExtrinsicQueue {
+	return &ExtrinsicQueue{
+		conn:        conn,
+		signer:      signer,
+		submissions: make(chan submission),
+	}
+}
+
+// Start submits the queued extrinsics until the ctx is cancelled.
+func (q *ExtrinsicQueue) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-q.submissions:
+			block, ext, err := q.submit(ctx, s.build)
+			s.result <- submissionResult{block: block, ext: ext, err: err}
+		}
+	}
+}
+
+// Submit queues the extrinsic and waits till it is finalized.
+// Returns the finalized block including the extrinsic and the submitted extrinsic.
+func (q *ExtrinsicQueue) Submit(ctx context.Context, build BuildExtrinsic) (types.Hash, types.Extrinsic, error) {
+	s := submission{build: build, result: make(chan submissionResult, 1)}
+	select {
+	case <-ctx.Done():
+		return types.Hash{}, types.Extrinsic{}, ctx.Err()
+	case q.submissions <- s:
+	}
+
+	r := <-s.result
+	return r.block, r.ext, r.err
+}
+
+func (q *ExtrinsicQueue) submit(ctx context.Context, build BuildExtrinsic) (block types.Hash, ext types.Extrinsic,
+	err error) {
+	for attempt := 1; ; attempt++ {
+		nonce, err := fetchNonce(q.conn.API(), q.signer.Address)
+		if err != nil {
+			return block, ext, err
+		}
+
+		var expiresAt uint64
+		ext, expiresAt, err = build(nonce)
+		if err != nil {
+			return block, ext, err
+		}
+
+		block, err = submitAndWatch(ctx, q.conn.API(), ext, expiresAt)
+		switch {
+		case err == nil || attempt == maxSubmitAttempts:
+			return block, ext, err
+		case err == errExtrinsicExpired:
+			log.Printf("Extrinsic with nonce %d expired. Resubmitting...\n", nonce)
+		case isNonceConflict(err):
+			log.Printf("Nonce %d conflicts with another transaction: %v. Resubmitting...\n", nonce, err)
+		default:
+			return block, ext, err
+		}
+	}
+}
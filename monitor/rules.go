@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MetricRule is a threshold rule evaluated against the scraped metrics of every node.
+// Rule can either be defined with the individual fields or with Expr.
+// Example Expr: substrate_ready_transactions_number > 500 for 10m => Warn
+type MetricRule struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+
+	// Metric is the series selector. Example: substrate_block_height{status="best"}
+	Metric    string   `json:"metric"`
+	Operator  string   `json:"operator"`
+	Threshold float64  `json:"threshold"`
+	For       Duration `json:"for"`
+	Severity  Severity `json:"severity"`
+}
+
+func (r MetricRule) String() string {
+	s := fmt.Sprintf("%s %s %v", r.Metric, r.Operator, r.Threshold)
+	if r.For.Duration > 0 {
+		s = fmt.Sprintf("%s for %s", s, r.For)
+	}
+
+	return fmt.Sprintf("%s => %s", s, r.Severity)
+}
+
+var comparators = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+var ruleExprRegex = regexp.MustCompile(`^(.+?)\s*(>=|<=|==|!=|>|<)\s*(\S+)(?:\s+for\s+(\S+))?\s*=>\s*(\w+)$`)
+
+// parseRuleExpr fills the rule fields from the expr.
+func parseRuleExpr(r MetricRule) (MetricRule, error) {
+	m := ruleExprRegex.FindStringSubmatch(strings.TrimSpace(r.Expr))
+	if m == nil {
+		return r, fmt.Errorf("invalid rule expression: %q", r.Expr)
+	}
+
+	threshold, err := strconv.ParseFloat(m[3], 64)
+	if err != nil {
+		return r, fmt.Errorf("invalid rule threshold: %q", m[3])
+	}
+
+	if m[4] != "" {
+		if err := r.For.Set(m[4]); err != nil {
+			return r, fmt.Errorf("invalid rule duration: %q", m[4])
+		}
+	}
+
+	severity, err := ParseSeverity(m[5])
+	if err != nil {
+		return r, err
+	}
+
+	r.Metric, r.Operator, r.Threshold, r.Severity = m[1], m[2], threshold, severity
+	return r, nil
+}
+
+// compiledRule is the metric rule with the selector parsed.
+type compiledRule struct {
+	MetricRule
+	metric   string
+	matchers []*LabelMatcher
+	compare  func(v, threshold float64) bool
+}
+
+func compileRules(rules []MetricRule) ([]compiledRule, error) {
+	res := make([]compiledRule, 0, len(rules))
+	for i, r := range rules {
+		if r.Expr != "" {
+			var err error
+			r, err = parseRuleExpr(r)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+		}
+
+		metric, matchers, err := ParseSelector(r.Metric)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+
+		compare, ok := comparators[r.Operator]
+		if !ok {
+			return nil, fmt.Errorf("rule %d: unknown operator %q", i, r.Operator)
+		}
+
+		res = append(res, compiledRule{
+			MetricRule: r,
+			metric:     metric,
+			matchers:   matchers,
+			compare:    compare,
+		})
+	}
+
+	return res, nil
+}
+
+// RuleEvaluator evaluates the metric rules of a single node and fires an incident
+// for every series breaching a rule.
+type RuleEvaluator struct {
+	node  NodeConfig
+	rules []compiledRule
+	// breaching are the incident IDs of the series that breached the rules on the last evaluation
+	breaching map[string]bool
+}
+
+func NewRuleEvaluator(node NodeConfig, rules []MetricRule) (*RuleEvaluator, error) {
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RuleEvaluator{node: node, rules: compiled, breaching: make(map[string]bool)}, nil
+}
+
+// Evaluate checks every rule against the families. Incidents of series no longer breaching the rule are resolved.
+func (e *RuleEvaluator) Evaluate(families Families, incidents *IncidentManager) {
+	breaching := make(map[string]bool)
+	for i, r := range e.rules {
+		for _, s := range families.Select(r.metric, r.matchers...) {
+			if !r.compare(s.Value, r.Threshold) {
+				continue
+			}
+
+			key := s.Labels.String()
+			msg := fmt.Sprintf("%s%s is %v (%s %v)", s.Name, key, s.Value, r.Operator, r.Threshold)
+			if r.Name != "" {
+				msg = fmt.Sprintf("%s: %s", r.Name, msg)
+			}
+
+			id := nodeIncidentID(e.node, fmt.Sprintf("rule/%d/%s%s", i, s.Name, key))
+			breaching[id] = true
+			incidents.Fire(Incident{
+				ID:       id,
+				Source:   e.node.Name,
+				Severity: r.Severity,
+				Message:  msg,
+				For:      r.For.Duration,
+				Details: map[string]interface{}{
+					"rule":   r.String(),
+					"labels": s.Labels,
+					"value":  s.Value,
+				},
+			})
+		}
+	}
+
+	for id := range e.breaching {
+		if !breaching[id] {
+			incidents.Resolve(id)
+		}
+	}
+	e.breaching = breaching
+}
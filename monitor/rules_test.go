@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRuleExpr(t *testing.T) {
+	tests := []struct {
+		expr string
+		want MetricRule
+	}{
+		{
+			expr: "substrate_ready_transactions_number > 500 for 10m => Warn",
+			want: MetricRule{Metric: "substrate_ready_transactions_number", Operator: ">", Threshold: 500,
+				For: Duration{10 * time.Minute}, Severity: Warn},
+		},
+		{
+			expr: `substrate_sub_libp2p_peers_count{chain="polkadot"} <= 2 => alert`,
+			want: MetricRule{Metric: `substrate_sub_libp2p_peers_count{chain="polkadot"}`, Operator: "<=", Threshold: 2,
+				Severity: Alert},
+		},
+		{
+			expr: `substrate_block_height{status="best"}<1e3=>info`,
+			want: MetricRule{Metric: `substrate_block_height{status="best"}`, Operator: "<", Threshold: 1000, Severity: Info},
+		},
+		{
+			expr: "  foo != -1.5 for 30s => error  ",
+			want: MetricRule{Metric: "foo", Operator: "!=", Threshold: -1.5, For: Duration{30 * time.Second},
+				Severity: Alert},
+		},
+		{
+			expr: "foo >= 1 => warning",
+			want: MetricRule{Metric: "foo", Operator: ">=", Threshold: 1, Severity: Warn},
+		},
+		{
+			expr: "foo == 0 => Alert",
+			want: MetricRule{Metric: "foo", Operator: "==", Threshold: 0, Severity: Alert},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseRuleExpr(MetricRule{Name: "rule", Expr: tt.expr})
+			if err != nil {
+				t.Fatalf("parseRuleExpr() error = %v", err)
+			}
+
+			tt.want.Name, tt.want.Expr = "rule", tt.expr
+			if got != tt.want {
+				t.Errorf("parseRuleExpr() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRuleExprErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		err  string
+	}{
+		{name: "missing severity", expr: "foo > 1", err: "invalid rule expression"},
+		{name: "missing operator", expr: "foo 1 => Warn", err: "invalid rule expression"},
+		{name: "missing metric", expr: "> 1 => Warn", err: "invalid rule expression"},
+		{name: "invalid threshold", expr: "foo > lots => Warn", err: "invalid rule threshold"},
+		{name: "invalid duration", expr: "foo > 1 for ever => Warn", err: "invalid rule duration"},
+		{name: "unknown severity", expr: "foo > 1 => Panic", err: "unknown severity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRuleExpr(MetricRule{Expr: tt.expr})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseRuleExpr() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompileRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule MetricRule
+		err  string
+	}{
+		{name: "unknown operator", rule: MetricRule{Metric: "foo", Operator: "=~"}, err: `rule 0: unknown operator "=~"`},
+		{name: "invalid selector", rule: MetricRule{Metric: "foo{a=b}", Operator: ">"}, err: "rule 0:"},
+		{name: "invalid expr", rule: MetricRule{Expr: "foo"}, err: "rule 0: invalid rule expression"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileRules([]MetricRule{tt.rule})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("compileRules() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
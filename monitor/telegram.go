@@ -15,17 +15,21 @@ type Telegram struct {
 	chatID      string
 	botUsername string
 	severity    Severity
-	prevVS      ValidatorStats
+	nodes       []*Node
+	prevVS      map[string]ValidatorStats
 	mu          sync.RWMutex
 	accountant  *Accountant
+	chain       *ChainMonitor
 }
 
-func NewTelegramBot(config Config) *Telegram {
+func NewTelegramBot(config Config, nodes []*Node) *Telegram {
 	return &Telegram{
 		client:      tgo.NewClient(config.TelegramKey),
 		chatID:      config.TelegramChatID,
 		severity:    Severity(config.TelegramSeverity),
 		botUsername: config.TelegramBotUsername,
+		nodes:       nodes,
+		prevVS:      make(map[string]ValidatorStats),
 	}
 }
 
@@ -35,6 +39,12 @@ func (t *Telegram) SetAccountant(acc *Accountant) {
 	t.accountant = acc
 }
 
+func (t *Telegram) SetChainMonitor(chain *ChainMonitor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.chain = chain
+}
+
 func (t *Telegram) Start(ctx context.Context) {
 	updatesChan := t.client.GetUpdatesChan(tgo.GetUpdatesParams{
 		Timeout: 60,
@@ -63,7 +73,15 @@ func (t *Telegram) Start(ctx context.Context) {
 			},
 			{
 				Command:     "payout",
-				Description: "Payout to nominators",
+				Description: "Payout to nominators. /payout dryrun only reports the plan and the fees",
+			},
+			{
+				Command:     "keys",
+				Description: "Check the validator nodes have the on-chain session keys",
+			},
+			{
+				Command:     "points",
+				Description: "Era points of the stash in the recent eras",
 			},
 		},
 	})
@@ -80,10 +98,18 @@ func (t *Telegram) Start(ctx context.Context) {
 				continue
 			}
 
-			msg := t.fetchCommand(strings.ToLower(update.Message.Text))
+			args := strings.Fields(strings.ToLower(update.Message.Text))
+			if len(args) == 0 {
+				continue
+			}
+
+			msg := t.fetchCommand(args[0])
 			switch msg {
 			case "metrics":
-				t.sendMetrics(update.Message.ID)
+				for _, node := range t.nodes {
+					t.sendMetrics(update.Message.ID, node)
+				}
+				t.sendMembership(update.Message.ID)
 			case "info":
 				t.updateSeverity(Info)
 				t.sendString(update.Message.ID, fmt.Sprintf("Log level: Info %s", OkayEmoji), true)
@@ -94,7 +120,11 @@ func (t *Telegram) Start(ctx context.Context) {
 				t.updateSeverity(Alert)
 				t.sendString(update.Message.ID, fmt.Sprintf("Log level: Error %s", ErrorEmoji), true)
 			case "payout":
-				t.accountant.Payout()
+				go t.payout(ctx, update.Message.ID, len(args) > 1 && args[1] == "dryrun")
+			case "keys":
+				t.sendSessionKeys(update.Message.ID)
+			case "points":
+				t.sendPoints(update.Message.ID)
 			}
 		}
 	}
@@ -140,22 +170,40 @@ func (t *Telegram) Notify(severity Severity, message string) {
 	}
 }
 
+func (t *Telegram) NotifyIncident(incident Incident) {
+	t.mu.RLock()
+	current := t.severity
+	t.mu.RUnlock()
+
+	if current > incident.Severity {
+		return
+	}
+
+	if incident.State == IncidentResolved {
+		t.sendString(0, wrapMessage(OkayEmoji, incident.String()), true)
+		return
+	}
+
+	t.Notify(incident.Severity, incident.String())
+}
+
 func wrapMessage(emoji, message string) string {
 	return fmt.Sprintf("Status: %s\n%s", emoji, message)
 }
-func (t *Telegram) sendMetrics(replyID int) {
-	metrics, err := FetchMetrics()
+func (t *Telegram) sendMetrics(replyID int, node *Node) {
+	metrics, err := FetchMetrics(node)
 	if err != nil {
-		t.sendString(replyID, wrapMessage(ErrorEmoji, err.Error()), true)
+		t.sendString(replyID, wrapMessage(ErrorEmoji, fmt.Sprintf("[%s] %v", node.Name, err)), true)
 		return
 	}
 
+	prevVS := t.prevVS[node.Name]
 	if metrics.ValidatorStats.LastProduced == nil {
-		metrics.ValidatorStats.LastProduced = t.prevVS.LastProduced
-		metrics.ValidatorStats.IsValidating = t.prevVS.IsValidating
+		metrics.ValidatorStats.LastProduced = prevVS.LastProduced
+		metrics.ValidatorStats.IsValidating = prevVS.IsValidating
 	}
 
-	str := metrics.String()
+	str := fmt.Sprintf("*%s*\n%s", escapeMarkdown(node.Name), metrics.String())
 	_, err = t.client.SendMessage(tgo.SendMessageParams{
 		ChatID:                t.chatID,
 		Text:                  str,
@@ -166,7 +214,76 @@ func (t *Telegram) sendMetrics(replyID int) {
 	if err != nil {
 		log.Printf("failed to send metrics to telegram bot: %v\n", err)
 	}
-	t.prevVS = metrics.ValidatorStats
+	t.prevVS[node.Name] = metrics.ValidatorStats
+}
+
+func (t *Telegram) sendMembership(replyID int) {
+	t.mu.RLock()
+	chain := t.chain
+	t.mu.RUnlock()
+	if chain == nil {
+		return
+	}
+
+	t.sendString(replyID, chain.MembershipStatus(), false)
+}
+
+func (t *Telegram) payout(ctx context.Context, replyID int, dryRun bool) {
+	t.mu.RLock()
+	acc := t.accountant
+	t.mu.RUnlock()
+	if acc == nil {
+		t.sendString(replyID, "Accountant is disabled", false)
+		return
+	}
+
+	acc.Payout(ctx, dryRun)
+}
+
+func (t *Telegram) sendSessionKeys(replyID int) {
+	t.mu.RLock()
+	chain := t.chain
+	t.mu.RUnlock()
+	if chain == nil {
+		t.sendString(replyID, "Chain monitor is disabled", false)
+		return
+	}
+
+	msg, err := chain.CheckSessionKeys()
+	if err != nil {
+		t.sendString(replyID, wrapMessage(ErrorEmoji, fmt.Sprintf("Failed to check session keys: %v", err)), true)
+		return
+	}
+
+	t.sendString(replyID, msg, false)
+}
+
+func (t *Telegram) sendPoints(replyID int) {
+	t.mu.RLock()
+	chain := t.chain
+	t.mu.RUnlock()
+	if chain == nil {
+		t.sendString(replyID, "Chain monitor is disabled", false)
+		return
+	}
+
+	msg, err := chain.PointsHistory()
+	if err != nil {
+		t.sendString(replyID, wrapMessage(ErrorEmoji, fmt.Sprintf("Failed to fetch era points: %v", err)), true)
+		return
+	}
+
+	t.sendString(replyID, msg, false)
+}
+
+var markdownEscaper = strings.NewReplacer(
+	"_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)", "~", "\\~", "`", "\\`",
+	">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-", "=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}",
+	".", "\\.", "!", "\\!")
+
+// escapeMarkdown escapes the MarkdownV2 reserved characters in s.
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
 }
 
 func (t *Telegram) sendString(replyID int, msg string, notify bool) {
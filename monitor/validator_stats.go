@@ -0,0 +1,76 @@
+package main
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+// validatorStatsWindow is how far back the produced blocks are counted.
+const validatorStatsWindow = 4 * time.Hour
+
+type ValidatorStats struct {
+	IsValidating   bool  `json:"is_validating"`
+	BlocksProduced int   `json:"blocks_produced"`
+	LastProduced   *bint `json:"last_produced"`
+}
+
+var valRegex = regexp.MustCompile(`🎁 Prepared block for proposing at ([0-9]+)`)
+
+type producedBlock struct {
+	at     time.Time
+	number *bint
+}
+
+// ValidatorStatsAggregator aggregates the node log messages into ValidatorStats.
+type ValidatorStatsAggregator struct {
+	window time.Duration
+
+	mu       sync.RWMutex
+	produced []producedBlock
+}
+
+func NewValidatorStatsAggregator(window time.Duration) *ValidatorStatsAggregator {
+	return &ValidatorStatsAggregator{window: window}
+}
+
+// Observe records the blocks produced in the log message logged at the given time.
+func (a *ValidatorStatsAggregator) Observe(at time.Time, message string) {
+	res := valRegex.FindAllStringSubmatch(message, -1)
+	if len(res) < 1 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range res {
+		if len(s) > 1 {
+			a.produced = append(a.produced, producedBlock{at: at, number: mustBigInt(s[1])})
+		}
+	}
+	a.prune(time.Now())
+}
+
+// Stats returns the validator stats of the blocks produced within the window.
+func (a *ValidatorStatsAggregator) Stats() ValidatorStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prune(time.Now())
+	if len(a.produced) < 1 {
+		return ValidatorStats{}
+	}
+
+	return ValidatorStats{
+		IsValidating:   true,
+		BlocksProduced: len(a.produced),
+		LastProduced:   a.produced[len(a.produced)-1].number,
+	}
+}
+
+func (a *ValidatorStatsAggregator) prune(now time.Time) {
+	var i int
+	for i < len(a.produced) && now.Sub(a.produced[i].at) > a.window {
+		i++
+	}
+	a.produced = a.produced[i:]
+}